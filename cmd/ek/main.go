@@ -25,6 +25,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"time"
@@ -100,7 +101,7 @@ func loadEndpoints(c *client.Client, me *url.URL, filename string) error {
 		}
 		defer f.Close()
 
-		endpoints, err = indieauth.GetEndpoints(me)
+		endpoints, err = indieauth.GetEndpoints(http.DefaultClient, me)
 		if err != nil {
 			return err
 		}
@@ -151,6 +152,7 @@ Commands:
 	channels NAME                create channel with NAME
 	channels UID NAME            update channel UID with NAME
 	channels -delete UID         delete channel with UID
+	channels -order UID...       change the order of the channels
 
 	timeline UID                 show posts for channel UID
 	timeline UID -after AFTER    show posts for channel UID starting from AFTER
@@ -166,6 +168,16 @@ Commands:
 
 	unfollow UID URL             unfollow URL on channel UID
 
+	feeds status                 show feeds that failed to fetch
+
+	mute UID                     show muted users for channel UID
+	mute UID URL                 mute user with URL on channel UID
+	unmute UID URL               unmute user with URL on channel UID
+
+	block                        show blocked users
+	block URL                    block user with URL
+	unblock URL                  unblock user with URL
+
 	export opml                  export feeds as OPML
 	import opml FILENAME         import OPML feeds
 
@@ -197,7 +209,7 @@ Global arguments:
 			log.Fatal(err)
 		}
 
-		endpoints, err := indieauth.GetEndpoints(me)
+		endpoints, err := indieauth.GetEndpoints(http.DefaultClient, me)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -205,7 +217,7 @@ Global arguments:
 		clientID := "https://p83.nl/microsub-client"
 		scope := "read follow mute block channels"
 
-		token, err := indieauth.Authorize(me, endpoints, clientID, scope)
+		token, err := indieauth.Authorize(http.DefaultClient, me, endpoints, clientID, scope)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -285,7 +297,19 @@ func performCommands(sub microsub.Microsub, commands []string) {
 		fmt.Printf("%s\n", channel.UID)
 	}
 
-	if len(commands) == 3 && commands[0] == "channels" {
+	if len(commands) >= 3 && commands[0] == "channels" && commands[1] == "-order" {
+		var uids []string
+		for _, c := range commands[2:] {
+			uid, _ := channelID(ctx, sub, c)
+			uids = append(uids, uid)
+		}
+		err := sub.ChannelsOrder(ctx, uids)
+		if err != nil {
+			log.Fatalf("An error occurred: %s\n", err)
+		}
+	}
+
+	if len(commands) == 3 && commands[0] == "channels" && commands[1] != "-order" {
 		if commands[1] == "-delete" {
 			uid, _ := channelID(ctx, sub, commands[2])
 			err := sub.ChannelsDelete(ctx, uid)
@@ -311,11 +335,11 @@ func performCommands(sub microsub.Microsub, commands []string) {
 		var err error
 
 		if len(commands) == 4 && commands[2] == "-after" {
-			timeline, err = sub.TimelineGet(ctx, "", commands[3], channel)
+			timeline, err = sub.TimelineGet(ctx, "", commands[3], channel, microsub.TimelineFilter{})
 		} else if len(commands) == 4 && commands[2] == "-before" {
-			timeline, err = sub.TimelineGet(ctx, commands[3], "", channel)
+			timeline, err = sub.TimelineGet(ctx, commands[3], "", channel, microsub.TimelineFilter{})
 		} else {
-			timeline, err = sub.TimelineGet(ctx, "", "", channel)
+			timeline, err = sub.TimelineGet(ctx, "", "", channel, microsub.TimelineFilter{})
 		}
 
 		if err != nil {
@@ -401,6 +425,63 @@ func performCommands(sub microsub.Microsub, commands []string) {
 		}
 	}
 
+	if len(commands) == 2 && commands[0] == "feeds" && commands[1] == "status" {
+		showFeedStatus(ctx, sub)
+	}
+
+	if len(commands) == 2 && commands[0] == "mute" {
+		uid, _ := channelID(ctx, sub, commands[1])
+		muted, err := sub.MuteGetList(ctx, uid)
+		if err != nil {
+			log.Fatalf("An error occurred: %s\n", err)
+		}
+		for _, card := range muted {
+			fmt.Println(card.URL)
+		}
+	}
+
+	if len(commands) == 3 && commands[0] == "mute" {
+		uid, _ := channelID(ctx, sub, commands[1])
+		u := commands[2]
+		err := sub.Mute(ctx, uid, u)
+		if err != nil {
+			log.Fatalf("An error occurred: %s\n", err)
+		}
+	}
+
+	if len(commands) == 3 && commands[0] == "unmute" {
+		uid, _ := channelID(ctx, sub, commands[1])
+		u := commands[2]
+		err := sub.Unmute(ctx, uid, u)
+		if err != nil {
+			log.Fatalf("An error occurred: %s\n", err)
+		}
+	}
+
+	if len(commands) == 1 && commands[0] == "block" {
+		blocked, err := sub.BlockGetList(ctx)
+		if err != nil {
+			log.Fatalf("An error occurred: %s\n", err)
+		}
+		for _, card := range blocked {
+			fmt.Println(card.URL)
+		}
+	}
+
+	if len(commands) == 2 && commands[0] == "block" {
+		err := sub.Block(ctx, commands[1])
+		if err != nil {
+			log.Fatalf("An error occurred: %s\n", err)
+		}
+	}
+
+	if len(commands) == 2 && commands[0] == "unblock" {
+		err := sub.Unblock(ctx, commands[1])
+		if err != nil {
+			log.Fatalf("An error occurred: %s\n", err)
+		}
+	}
+
 	if len(commands) == 2 && commands[0] == "export" {
 		filetype := commands[1]
 
@@ -441,6 +522,46 @@ func performCommands(sub microsub.Microsub, commands []string) {
 	}
 }
 
+// showFeedStatus shows the feeds of all channels that failed to fetch. The
+// status is an ekster extension, feeds without it are skipped.
+func showFeedStatus(ctx context.Context, sub microsub.Microsub) {
+	channels, err := sub.ChannelsGetList(ctx)
+	if err != nil {
+		log.Fatalf("An error occurred: %s\n", err)
+	}
+
+	seen := make(map[string]bool)
+	unhealthy := 0
+
+	for _, c := range channels {
+		feeds, err := sub.FollowGetList(ctx, c.UID)
+		if err != nil {
+			log.Printf("An error occurred: %s\n", err)
+			continue
+		}
+		for _, feed := range feeds {
+			if feed.Status == nil || feed.Status.Healthy() || seen[feed.URL] {
+				continue
+			}
+			seen[feed.URL] = true
+			unhealthy++
+
+			state := "failing"
+			if feed.Status.Dead {
+				state = "gone"
+			}
+			fmt.Printf("%-8s %3d %3d  %s\n", state, feed.Status.LastStatus, feed.Status.ConsecutiveFailures, feed.URL)
+			if feed.Status.LastError != "" {
+				fmt.Printf("         %s: %s\n", feed.Status.LastErrorAt, feed.Status.LastError)
+			}
+		}
+	}
+
+	if unhealthy == 0 {
+		fmt.Println("All feeds are healthy")
+	}
+}
+
 func exportOPMLFromMicrosub(ctx context.Context, sub microsub.Microsub) {
 	output := opml.OPML{}
 	output.Head.Title = "Microsub channels and feeds"
@@ -21,18 +21,12 @@ package main
 import (
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
-	"time"
 
 	"github.com/pkg/errors"
 	"github.com/pstuifzand/ekster/pkg/server"
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
-
 // App is the main app structure
 type App struct {
 	options    AppOptions
@@ -66,6 +60,16 @@ func NewApp(options AppOptions) (*App, error) {
 	app.backend = backend
 
 	app.backend.AuthEnabled = options.AuthEnabled
+	app.backend.fetchWorkers = options.FetchWorkers
+	app.backend.hostLimiter = newHostLimiter(options.FetchHostInterval)
+	fetchClient, err = newFetchClient(options.FetchPolicy)
+	if err != nil {
+		return nil, err
+	}
+	app.backend.fetcher, err = newFetcher(options)
+	if err != nil {
+		return nil, err
+	}
 
 	app.hubBackend = &hubIncomingBackend{
 		baseURL:  options.BaseURL,
@@ -91,6 +95,7 @@ func NewApp(options AppOptions) (*App, error) {
 	http.Handle("/incoming/", &incomingHandler{
 		Backend:   app.hubBackend,
 		Processor: app.backend,
+		Notifier:  app.backend,
 	})
 
 	if !options.Headless {
@@ -75,8 +75,7 @@ func checkAuthToken(header string, tokenEndpoint string, token *auth.TokenRespon
 		return false, err
 	}
 
-	client := http.Client{}
-	res, err := client.Do(req)
+	res, err := fetchClient.Do(req)
 	if err != nil {
 		return false, err
 	}
@@ -21,15 +21,26 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/pstuifzand/ekster/pkg/microsub"
+	"github.com/pstuifzand/ekster/pkg/sse"
+	"github.com/pstuifzand/ekster/pkg/timeline"
+	"github.com/pstuifzand/ekster/pkg/userid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/html"
 )
 
 type DatabaseSuite struct {
@@ -77,7 +88,7 @@ type databaseSuite struct {
 }
 
 func (d *databaseSuite) TestGetChannelFromAuthorization() {
-	_, err := d.Database.Exec(`truncate "sources", "channels", "feeds", "subscriptions","items"`)
+	_, err := d.Database.Exec(`truncate "sources", "channels", "feeds", "channel_feeds", "subscriptions","items"`)
 	assert.NoError(d.T(), err, "truncate sources, channels, feeds")
 	row := d.Database.QueryRow(`INSERT INTO "channels" (uid, name, created_at, updated_at) VALUES ('abcdef', 'Channel', now(), now()) RETURNING "id"`)
 	var id int
@@ -99,6 +110,557 @@ func (d *databaseSuite) TestGetChannelFromAuthorization() {
 	assert.Equal(d.T(), "", c, "channel uid found")
 }
 
+// truncate empties the tables and restarts the ids, so the first channel has
+// id 1, which is the default user_id of a channel
+func (d *databaseSuite) truncate() {
+	_, err := d.Database.Exec(`TRUNCATE "channels", "feeds", "items" RESTART IDENTITY CASCADE`)
+	d.Require().NoError(err, "truncate")
+}
+
+// createChannel creates a channel of user 1
+func (d *databaseSuite) createChannel(uid string) {
+	_, err := d.Database.Exec(`INSERT INTO "channels" ("uid", "name", "created_at", "updated_at") VALUES ($1, $1, now(), now())`, uid)
+	d.Require().NoError(err, "insert channel")
+}
+
+// newTimeline creates a channel with a postgres-stream timeline
+func (d *databaseSuite) newTimeline(uid string) timeline.Backend {
+	d.createChannel(uid)
+	return timeline.Create(context.Background(), uid, "postgres-stream", nil, d.Database)
+}
+
+// addPagingItems adds item-0 to item-6 to tl. The items item-1 to item-5
+// have the same published date.
+func (d *databaseSuite) addPagingItems(tl timeline.Backend) {
+	for i := 0; i < 7; i++ {
+		published := "2022-01-01T12:00:00Z"
+		if i == 0 {
+			published = "2022-01-01T11:00:00Z"
+		} else if i == 6 {
+			published = "2022-01-01T13:00:00Z"
+		}
+		_, err := tl.AddItem(context.Background(), microsub.Item{ID: fmt.Sprintf("item-%d", i), Published: published})
+		d.Require().NoError(err, "add item")
+	}
+}
+
+func itemUIDs(items []microsub.Item) []string {
+	uids := []string{}
+	for _, item := range items {
+		uids = append(uids, item.ID)
+	}
+	return uids
+}
+
+func (d *databaseSuite) TestPostgresItemsPaging() {
+	ctx := context.Background()
+	d.truncate()
+	tl := d.newTimeline("paging")
+	d.addPagingItems(tl)
+
+	filter := microsub.TimelineFilter{Limit: 3}
+
+	page1, err := tl.Items(ctx, "", "", filter)
+	d.Require().NoError(err)
+	assert.Equal(d.T(), []string{"item-6", "item-5", "item-4"}, itemUIDs(page1.Items))
+	assert.Equal(d.T(), "", page1.Paging.Before)
+	assert.NotEqual(d.T(), "", page1.Paging.After)
+
+	page2, err := tl.Items(ctx, "", page1.Paging.After, filter)
+	d.Require().NoError(err)
+	assert.Equal(d.T(), []string{"item-3", "item-2", "item-1"}, itemUIDs(page2.Items))
+	assert.NotEqual(d.T(), "", page2.Paging.Before)
+	assert.NotEqual(d.T(), "", page2.Paging.After)
+
+	page3, err := tl.Items(ctx, "", page2.Paging.After, filter)
+	d.Require().NoError(err)
+	assert.Equal(d.T(), []string{"item-0"}, itemUIDs(page3.Items))
+	assert.NotEqual(d.T(), "", page3.Paging.Before)
+	assert.Equal(d.T(), "", page3.Paging.After)
+
+	// paging back returns the same pages
+	back2, err := tl.Items(ctx, page3.Paging.Before, "", filter)
+	d.Require().NoError(err)
+	assert.Equal(d.T(), itemUIDs(page2.Items), itemUIDs(back2.Items))
+	assert.NotEqual(d.T(), "", back2.Paging.Before)
+	assert.NotEqual(d.T(), "", back2.Paging.After)
+
+	back1, err := tl.Items(ctx, back2.Paging.Before, "", filter)
+	d.Require().NoError(err)
+	assert.Equal(d.T(), itemUIDs(page1.Items), itemUIDs(back1.Items))
+	assert.Equal(d.T(), "", back1.Paging.Before)
+	assert.NotEqual(d.T(), "", back1.Paging.After)
+}
+
+func (d *databaseSuite) TestPostgresMarkReadUntil() {
+	ctx := context.Background()
+	d.truncate()
+	tl := d.newTimeline("read-until")
+	d.addPagingItems(tl)
+
+	// item-3 has the same published date as item-1 to item-5
+	d.Require().NoError(tl.MarkReadUntil(ctx, "item-3"))
+
+	count, err := tl.Count(ctx)
+	d.Require().NoError(err)
+	assert.Equal(d.T(), 3, count)
+
+	page, err := tl.Items(ctx, "", "", microsub.TimelineFilter{Unread: true})
+	d.Require().NoError(err)
+	assert.Equal(d.T(), []string{"item-6", "item-5", "item-4"}, itemUIDs(page.Items))
+
+	// the newer items are read by a later item
+	d.Require().NoError(tl.MarkReadUntil(ctx, "item-6"))
+	count, err = tl.Count(ctx)
+	d.Require().NoError(err)
+	assert.Equal(d.T(), 0, count)
+
+	// an already read item is found, an unknown item is not
+	assert.NoError(d.T(), tl.MarkReadUntil(ctx, "item-6"))
+	assert.Equal(d.T(), timeline.ErrItemNotFound, tl.MarkReadUntil(ctx, "missing"))
+}
+
+func (d *databaseSuite) TestCopySortedSetReadItems() {
+	ctx := context.Background()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) {
+		return redis.Dial("tcp", d.RedisURL, redis.DialDatabase(1))
+	}}
+	defer pool.Close()
+
+	channel := fmt.Sprintf("test-copy-%d", time.Now().UnixNano())
+	src := timeline.Create(ctx, channel, "sorted-set", pool, d.Database)
+	for i := 0; i < 3; i++ {
+		_, err := src.AddItem(ctx, microsub.Item{
+			ID:        fmt.Sprintf("%s-%d", channel, i),
+			Published: fmt.Sprintf("2022-01-01T12:0%d:00Z", i),
+		})
+		assert.NoError(d.T(), err)
+	}
+	assert.NoError(d.T(), src.MarkRead(ctx, []string{channel + "-1"}))
+	assert.NoError(d.T(), src.Remove(ctx, []string{channel + "-2"}))
+
+	dst := timeline.NewMemory(channel)
+	n, err := timeline.Copy(ctx, dst, src)
+	assert.NoError(d.T(), err)
+	assert.Equal(d.T(), 2, n)
+
+	items, err := dst.ItemsByUID(ctx, []string{channel + "-0", channel + "-1"})
+	if assert.NoError(d.T(), err) {
+		assert.False(d.T(), items[0].Read)
+		assert.True(d.T(), items[1].Read)
+	}
+	_, err = dst.ItemsByUID(ctx, []string{channel + "-2"})
+	assert.Equal(d.T(), timeline.ErrItemNotFound, err)
+}
+
+func (d *databaseSuite) TestMigrateTimelineRoundTrip() {
+	ctx := context.Background()
+	d.truncate()
+	b := d.newMemoryBackend()
+
+	channel := fmt.Sprintf("migrate-%d", time.Now().UnixNano())
+	d.createChannel(channel)
+	tl, err := b.getTimeline(ctx, channel)
+	d.Require().NoError(err)
+	for i := 0; i < 3; i++ {
+		_, err := tl.AddItem(ctx, microsub.Item{
+			ID:        fmt.Sprintf("item-%d", i),
+			Published: fmt.Sprintf("2022-01-01T12:0%d:00Z", i),
+		})
+		d.Require().NoError(err)
+	}
+	d.Require().NoError(tl.MarkRead(ctx, []string{"item-0"}))
+	d.Require().NoError(tl.(timeline.Starrer).Star(ctx, []string{"item-1"}))
+
+	// the postgres timeline is cleared after the copy
+	d.Require().NoError(migrateTimeline(ctx, b, channel, "sorted-set"))
+	assert.Equal(d.T(), 0, d.count(`SELECT count(*) FROM "items"`))
+
+	tl, err = b.getTimeline(ctx, channel)
+	d.Require().NoError(err)
+	d.Require().NoError(tl.MarkUnread(ctx, []string{"item-0"}))
+	d.Require().NoError(tl.MarkRead(ctx, []string{"item-2"}))
+
+	// the changes on the sorted-set timeline are kept when migrating back
+	d.Require().NoError(migrateTimeline(ctx, b, channel, "postgres-stream"))
+	tl, err = b.getTimeline(ctx, channel)
+	d.Require().NoError(err)
+	items, err := tl.ItemsByUID(ctx, []string{"item-0", "item-1", "item-2"})
+	d.Require().NoError(err)
+	assert.False(d.T(), items[0].Read)
+	assert.True(d.T(), items[1].Starred)
+	assert.True(d.T(), items[2].Read)
+
+	count, err := tl.Count(ctx)
+	d.Require().NoError(err)
+	assert.Equal(d.T(), 2, count)
+
+	// the sorted-set timeline is cleared too
+	set := timeline.Create(ctx, channel, "sorted-set", b.pool, b.database)
+	count, err = set.Count(ctx)
+	d.Require().NoError(err)
+	assert.Equal(d.T(), 0, count)
+	_, err = set.ItemsByUID(ctx, []string{"item-2"})
+	assert.Equal(d.T(), timeline.ErrItemNotFound, err)
+}
+
+func (d *databaseSuite) TestPostgresPrune() {
+	ctx := context.Background()
+	d.truncate()
+	tl := d.newTimeline("prune")
+	for i := 0; i < 6; i++ {
+		_, err := tl.AddItem(ctx, microsub.Item{
+			ID:        fmt.Sprintf("item-%d", i),
+			Published: fmt.Sprintf("2022-01-01T1%d:00:00Z", i),
+		})
+		d.Require().NoError(err, "add item")
+	}
+	d.Require().NoError(tl.MarkRead(ctx, []string{"item-0", "item-1", "item-2", "item-4"}))
+	d.Require().NoError(tl.(timeline.Starrer).Star(ctx, []string{"item-1"}))
+
+	// item-0 to item-2 are too old and item-3 is not in the newest 2, but
+	// item-1 is starred and item-3 is unread
+	uids, err := tl.(timeline.Pruner).Prune(ctx, timeline.PruneOptions{
+		Before:      time.Date(2022, 1, 1, 12, 30, 0, 0, time.UTC),
+		MaxItems:    2,
+		KeepUnread:  true,
+		KeepStarred: true,
+	})
+	d.Require().NoError(err)
+	assert.ElementsMatch(d.T(), []string{"item-0", "item-2"}, uids)
+
+	page, err := tl.Items(ctx, "", "", microsub.TimelineFilter{})
+	d.Require().NoError(err)
+	assert.Equal(d.T(), []string{"item-5", "item-4", "item-3", "item-1"}, itemUIDs(page.Items))
+
+	// pruned items are not added again, but items older than the pruned items
+	// that were never in the channel are
+	added, err := tl.AddItem(ctx, microsub.Item{ID: "item-0", Published: "2022-01-01T10:00:00Z"})
+	d.Require().NoError(err)
+	assert.False(d.T(), added)
+	for _, item := range []microsub.Item{
+		{ID: "item-old", Published: "2022-01-01T11:30:00Z"},
+		{ID: "item-6", Published: "2022-01-01T16:00:00Z"},
+	} {
+		added, err := tl.AddItem(ctx, item)
+		d.Require().NoError(err)
+		assert.True(d.T(), added, item.ID)
+	}
+
+	// the starred item older than Before survives with only KeepStarred
+	uids, err = tl.(timeline.Pruner).Prune(ctx, timeline.PruneOptions{
+		Before:      time.Date(2022, 1, 1, 12, 30, 0, 0, time.UTC),
+		KeepStarred: true,
+	})
+	d.Require().NoError(err)
+	assert.ElementsMatch(d.T(), []string{"item-old"}, uids)
+	items, err := tl.ItemsByUID(ctx, []string{"item-1"})
+	if assert.NoError(d.T(), err) {
+		assert.True(d.T(), items[0].Starred)
+	}
+
+	// without KeepStarred the starred item is pruned too
+	uids, err = tl.(timeline.Pruner).Prune(ctx, timeline.PruneOptions{
+		Before: time.Date(2022, 1, 1, 12, 30, 0, 0, time.UTC),
+	})
+	d.Require().NoError(err)
+	assert.ElementsMatch(d.T(), []string{"item-1"}, uids)
+}
+
+// newMemoryBackend returns a memoryBackend that uses the databases of the suite
+func (d *databaseSuite) newMemoryBackend() *memoryBackend {
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) {
+		return redis.Dial("tcp", d.RedisURL, redis.DialDatabase(1))
+	}}
+	d.T().Cleanup(func() { pool.Close() })
+	return &memoryBackend{
+		broker:     sse.NewBroker(),
+		pool:       pool,
+		database:   d.Database,
+		hubBackend: &hubIncomingBackend{baseURL: "http://localhost/", pool: pool, database: d.Database},
+	}
+}
+
+func (d *databaseSuite) count(query string, args ...interface{}) int {
+	var n int
+	d.Require().NoError(d.Database.QueryRow(query, args...).Scan(&n), query)
+	return n
+}
+
+func (d *databaseSuite) TestSharedFeed() {
+	allowLoopback(d.T())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Shared</title><link>https://example.com/</link>
+<item><guid>https://example.com/1</guid><title>One</title><link>https://example.com/1</link><pubDate>Sat, 01 Jan 2022 12:00:00 GMT</pubDate></item>
+</channel></rss>`)
+	}))
+	defer server.Close()
+
+	ctx := userid.NewContext(context.Background(), 1)
+	d.truncate()
+	d.createChannel("shared-a")
+	d.createChannel("shared-b")
+	b := d.newMemoryBackend()
+
+	// following the url from two channels creates one feed
+	_, err := b.FollowURL(ctx, "shared-a", server.URL)
+	d.Require().NoError(err)
+	_, err = b.FollowURL(ctx, "shared-b", server.URL)
+	d.Require().NoError(err)
+	assert.Equal(d.T(), 1, d.count(`SELECT count(*) FROM "feeds" WHERE "url" = $1`, server.URL))
+	assert.Equal(d.T(), 2, d.count(`SELECT count(*) FROM "channel_feeds"`))
+
+	// the same item uid is added to both channels
+	assert.Equal(d.T(), 1, d.count(`SELECT count(DISTINCT "uid") FROM "items"`))
+	assert.Equal(d.T(), 2, d.count(`SELECT count(DISTINCT "channel_id") FROM "items"`))
+
+	// the feed is kept while a channel follows it
+	d.Require().NoError(b.UnfollowURL(ctx, "shared-a", server.URL))
+	assert.Equal(d.T(), 1, d.count(`SELECT count(*) FROM "feeds" WHERE "url" = $1`, server.URL))
+	assert.Equal(d.T(), 1, d.count(`SELECT count(*) FROM "items"`))
+
+	// the last unfollow removes the feed
+	d.Require().NoError(b.UnfollowURL(ctx, "shared-b", server.URL))
+	assert.Equal(d.T(), 0, d.count(`SELECT count(*) FROM "feeds" WHERE "url" = $1`, server.URL))
+	assert.Equal(d.T(), 0, d.count(`SELECT count(*) FROM "channel_feeds"`))
+	assert.Equal(d.T(), 0, d.count(`SELECT count(*) FROM "items"`))
+}
+
+func (d *databaseSuite) TestMutesPerUser() {
+	d.truncate()
+	d.createChannel("mute-a")
+	// the user_id of a channel references a channel id, channel 2 is user 2
+	d.createChannel("mute-b")
+	_, err := d.Database.Exec(`UPDATE "channels" SET "user_id" = 2 WHERE "uid" = 'mute-b'`)
+	d.Require().NoError(err)
+	b := d.newMemoryBackend()
+
+	user1 := userid.NewContext(context.Background(), 1)
+	user2 := userid.NewContext(context.Background(), 2)
+
+	d.Require().NoError(b.Mute(user1, "mute-a", "https://example.com/a"))
+
+	// another user can't list, add or remove the mutes of the channel
+	_, err = b.MuteGetList(user2, "mute-a")
+	assert.ErrorIs(d.T(), err, microsub.ErrChannelNotFound)
+	assert.ErrorIs(d.T(), b.Mute(user2, "mute-a", "https://example.com/b"), microsub.ErrChannelNotFound)
+	assert.ErrorIs(d.T(), b.Unmute(user2, "mute-a", "https://example.com/a"), microsub.ErrChannelNotFound)
+	assert.ErrorIs(d.T(), b.Unmute(user1, "missing", "https://example.com/a"), microsub.ErrChannelNotFound)
+
+	muted, err := b.MuteGetList(user1, "mute-a")
+	d.Require().NoError(err)
+	assert.Equal(d.T(), []microsub.Card{{Type: "card", URL: "https://example.com/a"}}, muted)
+
+	d.Require().NoError(b.Unmute(user1, "mute-a", "https://example.com/a"))
+	muted, err = b.MuteGetList(user1, "mute-a")
+	d.Require().NoError(err)
+	assert.Empty(d.T(), muted)
+}
+
+func (d *databaseSuite) TestBlockRemovesItems() {
+	ctx := userid.NewContext(context.Background(), 1)
+	d.truncate()
+	b := d.newMemoryBackend()
+
+	blocked := &microsub.Card{Type: "card", URL: "https://blocked.example/"}
+	other := &microsub.Card{Type: "card", URL: "https://other.example/"}
+
+	setChannel := fmt.Sprintf("block-set-%d", time.Now().UnixNano())
+	channels := []string{"block-pg", setChannel}
+	for _, channel := range channels {
+		d.createChannel(channel)
+	}
+	d.Require().NoError(b.saveSetting(setChannel, channelSetting{ChannelType: "sorted-set"}))
+
+	for _, channel := range channels {
+		tl, err := b.getTimeline(ctx, channel)
+		d.Require().NoError(err)
+		for _, item := range []microsub.Item{
+			{ID: channel + "-blocked-1", Published: "2022-01-01T12:00:00Z", Author: blocked},
+			{ID: channel + "-blocked-2", Published: "2022-01-01T12:01:00Z", Author: blocked},
+			{ID: channel + "-other", Published: "2022-01-01T12:02:00Z", Author: other},
+		} {
+			_, err := tl.AddItem(ctx, item)
+			d.Require().NoError(err)
+		}
+		d.Require().NoError(tl.MarkRead(ctx, []string{channel + "-blocked-2"}))
+	}
+
+	d.Require().NoError(b.Block(ctx, blocked.URL))
+
+	// the items of the blocked user are deleted from the database
+	assert.Equal(d.T(), 0, d.count(`SELECT count(*) FROM "items" WHERE "uid" LIKE 'block-pg-blocked-%'`))
+	assert.Equal(d.T(), 1, d.count(`SELECT count(*) FROM "items" WHERE "uid" = 'block-pg-other'`))
+
+	for _, channel := range channels {
+		tl, err := b.getTimeline(ctx, channel)
+		d.Require().NoError(err)
+
+		for _, uid := range []string{channel + "-blocked-1", channel + "-blocked-2"} {
+			_, err = tl.ItemsByUID(ctx, []string{uid})
+			assert.Equal(d.T(), timeline.ErrItemNotFound, err, uid)
+
+			// a fetch doesn't add the deleted items again
+			added, err := tl.AddItem(ctx, microsub.Item{ID: uid, Published: "2022-01-01T12:00:00Z", Author: blocked})
+			d.Require().NoError(err)
+			assert.False(d.T(), added, uid)
+		}
+
+		_, err = tl.ItemsByUID(ctx, []string{channel + "-other"})
+		assert.NoError(d.T(), err)
+
+		// new items of the blocked user are skipped
+		isBlocked, err := b.isBlocked(channel, microsub.Item{Author: blocked})
+		d.Require().NoError(err)
+		assert.True(d.T(), isBlocked)
+	}
+}
+
+func (d *databaseSuite) TestUnsubscribeContinuesAfterFailure() {
+	allowLoopback(d.T())
+	var topics []string
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		topic := r.FormValue("hub.topic")
+		topics = append(topics, topic)
+		if topic == "https://example.com/failing" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+
+	b := d.newMemoryBackend()
+	err := b.hubBackend.Unsubscribe([]Feed{
+		{ID: 1, URL: "https://example.com/failing", Hub: hub.URL, Callback: "http://localhost/incoming/1"},
+		{ID: 2, URL: "https://example.com/working", Hub: hub.URL, Callback: "http://localhost/incoming/2"},
+	})
+
+	// the second subscription is unsubscribed after the first failed
+	assert.Error(d.T(), err)
+	assert.Contains(d.T(), err.Error(), "1 of 2")
+	assert.Equal(d.T(), []string{"https://example.com/failing", "https://example.com/working"}, topics)
+}
+
+func (d *databaseSuite) TestResubscribeUnsubscribesOldCallback() {
+	allowLoopback(d.T())
+	var requests []string
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.FormValue("hub.mode")+" "+r.FormValue("hub.callback"))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+
+	b := d.newMemoryBackend()
+	h := b.hubBackend.(*hubIncomingBackend)
+	feed := Feed{ID: 1, URL: "https://example.com/feed", Hub: hub.URL, Callback: "http://localhost/incoming/1", URLSecret: "secret"}
+	d.Require().NoError(h.resubscribe(feed))
+
+	// the hub is asked to stop sending updates to the callback without the
+	// url secret
+	assert.Equal(d.T(), []string{
+		"subscribe " + h.callbackURL(feed),
+		"unsubscribe http://localhost/incoming/1",
+	}, requests)
+}
+
+// addFormValues adds the values that a browser submits for the inputs and
+// selects below n to values
+func addFormValues(n *html.Node, values url.Values) {
+	if n.Type == html.ElementNode {
+		attrs := map[string]string{}
+		for _, attr := range n.Attr {
+			attrs[attr.Key] = attr.Val
+		}
+		_, checked := attrs["checked"]
+		switch {
+		case n.Data == "input" && attrs["type"] == "checkbox":
+			if checked {
+				values.Add(attrs["name"], attrs["value"])
+			}
+		case n.Data == "input":
+			values.Add(attrs["name"], attrs["value"])
+		case n.Data == "select":
+			_, multiple := attrs["multiple"]
+			var first string
+			var selected []string
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type != html.ElementNode || c.Data != "option" {
+					continue
+				}
+				var value string
+				var isSelected bool
+				for _, attr := range c.Attr {
+					if attr.Key == "value" {
+						value = attr.Val
+					} else if attr.Key == "selected" {
+						isSelected = true
+					}
+				}
+				if first == "" {
+					first = value
+				}
+				if isSelected {
+					selected = append(selected, value)
+				}
+			}
+			if len(selected) == 0 && !multiple {
+				selected = []string{first}
+			}
+			for _, value := range selected {
+				values.Add(attrs["name"], value)
+			}
+			return
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		addFormValues(c, values)
+	}
+}
+
+func (d *databaseSuite) TestChannelSettingsForm() {
+	d.truncate()
+	d.createChannel("settings-form")
+	b := d.newMemoryBackend()
+	h := &mainHandler{Backend: b, BaseURL: "http://localhost/", pool: b.pool}
+
+	conn := b.pool.Get()
+	defer conn.Close()
+	d.Require().NoError(saveSession("settings-form", &session{LoggedIn: true, UserID: 1}, conn))
+	cookie := &http.Cookie{Name: "session", Value: "settings-form"}
+
+	req := httptest.NewRequest(http.MethodGet, "/settings/channel?uid=settings-form", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	d.Require().Equal(http.StatusOK, rec.Code)
+
+	doc, err := html.Parse(rec.Body)
+	d.Require().NoError(err)
+	form := url.Values{}
+	addFormValues(doc, form)
+	assert.Equal(d.T(), "settings-form", form.Get("uid"))
+	assert.Equal(d.T(), "postgres-stream", form.Get("type"))
+
+	// the form as rendered, with a retention setting changed, is saved
+	form.Set("max_age", "30")
+	req = httptest.NewRequest(http.MethodPost, "/settings/channel", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(d.T(), http.StatusFound, rec.Code)
+	assert.Equal(d.T(), "/settings", rec.Header().Get("Location"))
+
+	setting, err := b.loadSetting("settings-form")
+	d.Require().NoError(err)
+	assert.Equal(d.T(), "postgres-stream", setting.ChannelType)
+	assert.Equal(d.T(), 30, setting.MaxAge)
+	assert.True(d.T(), setting.KeepStarred)
+}
+
 func TestDatabaseSuite(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skip test for database")
@@ -115,8 +677,11 @@ func TestDatabaseSuite(t *testing.T) {
 		},
 	}
 
-	databaseURL = "postgres://postgres@database/ekster_testing?sslmode=disable&user=postgres&password=simple"
-	err := runMigrations(databaseURL)
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = runMigrations(db)
 	if err != nil {
 		log.Fatal(err)
 	}
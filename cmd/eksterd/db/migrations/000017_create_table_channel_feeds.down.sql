@@ -0,0 +1,31 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+DELETE FROM "items" "a" USING "items" "b" WHERE "a"."uid" = "b"."uid" AND "a"."id" > "b"."id";
+
+ALTER TABLE "items"
+    DROP CONSTRAINT "items_channel_id_uid_key",
+    ADD CONSTRAINT "items_uid_key" UNIQUE ("uid");
+
+ALTER TABLE "feeds" ADD COLUMN "channel_id" int references "channels" (id) on update cascade on delete cascade;
+
+UPDATE "feeds" "f" SET "channel_id" = (
+    SELECT MIN("cf"."channel_id") FROM "channel_feeds" "cf" WHERE "cf"."feed_id" = "f"."id"
+);
+
+DROP TABLE "channel_feeds";
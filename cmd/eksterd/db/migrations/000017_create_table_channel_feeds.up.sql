@@ -0,0 +1,34 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+CREATE TABLE "channel_feeds" (
+    "id" int primary key generated always as identity,
+    "channel_id" int not null references "channels" on delete cascade,
+    "feed_id" int not null references "feeds" on delete cascade,
+    "created_at" timestamptz DEFAULT current_timestamp,
+    UNIQUE ("channel_id", "feed_id")
+);
+
+INSERT INTO "channel_feeds" ("channel_id", "feed_id")
+SELECT "channel_id", "id" FROM "feeds" WHERE "channel_id" IS NOT NULL;
+
+ALTER TABLE "feeds" DROP COLUMN "channel_id";
+
+ALTER TABLE "items"
+    DROP CONSTRAINT "items_uid_key",
+    ADD CONSTRAINT "items_channel_id_uid_key" UNIQUE ("channel_id", "uid");
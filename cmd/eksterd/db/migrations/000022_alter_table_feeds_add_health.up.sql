@@ -0,0 +1,24 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+ALTER TABLE "feeds"
+    ADD COLUMN "last_success_at" TIMESTAMPTZ NULL,
+    ADD COLUMN "last_error_at" TIMESTAMPTZ NULL,
+    ADD COLUMN "last_error" TEXT NOT NULL DEFAULT '',
+    ADD COLUMN "consecutive_failures" INT NOT NULL DEFAULT 0,
+    ADD COLUMN "last_status" INT NOT NULL DEFAULT 0;
@@ -0,0 +1,89 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pstuifzand/ekster/pkg/fetch"
+)
+
+const (
+	// defaultFetchCache is the store of the fetch cache, when not configured
+	defaultFetchCache = "redis"
+
+	// defaultFetchCacheSize is the maximum size in bytes of the "memory" and
+	// "disk" stores, when not configured
+	defaultFetchCacheSize = 64 << 20
+)
+
+// fetchClient sends the requests of Fetch2 and the shared fetcher. It applies
+// the fetch policy that is set with the -fetch-policy option.
+var fetchClient = fetch.NewClient(fetch.DefaultPolicy())
+
+// newFetchClient creates the client for the fetch policy in the file
+// filename, or for the default policy when filename is empty
+func newFetchClient(filename string) (*http.Client, error) {
+	if filename == "" {
+		return fetch.NewClient(fetch.DefaultPolicy()), nil
+	}
+	policy, err := fetch.LoadPolicy(filename)
+	if err != nil {
+		return nil, err
+	}
+	return fetch.NewClient(policy), nil
+}
+
+// newFetcher creates the fetcher that is shared by Search, PreviewURL and the
+// fetching of mentions. The responses are cached in the store that is set with
+// the -fetch-cache option: "redis", "memory", "disk" or "none".
+func newFetcher(options AppOptions) (fetch.Fetcher, error) {
+	var store fetch.CacheStore
+	switch options.FetchCache {
+	case "redis":
+		store = fetch.NewRedisStore(options.pool, "http_cache:")
+	case "memory":
+		store = fetch.NewMemoryStore(options.FetchCacheSize)
+	case "disk":
+		if options.FetchCacheDir == "" {
+			return nil, fmt.Errorf("fetch cache %q needs a directory, use -fetch-cache-dir", options.FetchCache)
+		}
+		diskStore, err := fetch.NewDiskStore(options.FetchCacheDir, options.FetchCacheSize)
+		if err != nil {
+			return nil, err
+		}
+		store = diskStore
+	case "none", "":
+		return fetch.FetcherFunc(Fetch2), nil
+	default:
+		return nil, fmt.Errorf("unknown fetch cache %q", options.FetchCache)
+	}
+
+	return fetch.NewCachingFetcher(fetch.DoerFunc(doRequest), store), nil
+}
+
+// getFetcher returns the shared fetcher, or an uncached fetcher when it was not
+// set
+func (b *memoryBackend) getFetcher() fetch.Fetcher {
+	if b.fetcher == nil {
+		return fetch.FetcherFunc(Fetch2)
+	}
+	return b.fetcher
+}
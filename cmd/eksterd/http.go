@@ -31,6 +31,7 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -92,6 +93,11 @@ type settingsPage struct {
 type logsPage struct {
 	Session session
 }
+type feedsPage struct {
+	Session session
+
+	Feeds []feedHealth
+}
 
 type authPage struct {
 	Session     session
@@ -194,8 +200,7 @@ func verifyAuthCode(code, redirectURI, authEndpoint, clientID string) (bool, *au
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	client := http.Client{}
-	resp, err := client.Do(req)
+	resp, err := fetchClient.Do(req)
 	if err != nil {
 		return false, nil, err
 	}
@@ -275,7 +280,7 @@ func getAppInfo(clientID string) (app, error) {
 	if err != nil {
 		return app, err
 	}
-	resp, err := http.Get(clientID)
+	resp, err := fetchClient.Get(clientID)
 	if err != nil {
 		return app, err
 	}
@@ -392,7 +397,7 @@ func (h *mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			var page settingsPage
 			page.Session = sess
 			currentChannel := r.URL.Query().Get("uid")
-			page.Channels, err = h.Backend.ChannelsGetList(r.Context())
+			page.Channels, err = h.Backend.getChannels(r.Context())
 			if err != nil {
 				log.Printf("ERROR: %s\n", err)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -416,7 +421,7 @@ func (h *mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 					page.CurrentSetting = setting
 
 					if page.CurrentSetting.ChannelType == "" {
-						page.CurrentSetting.ChannelType = "postgres-stream"
+						page.CurrentSetting.ChannelType = defaultTimelineType
 					}
 
 					page.ExcludedTypeNames = map[string]string{
@@ -443,6 +448,40 @@ func (h *mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				fmt.Fprintf(w, "ERROR: %s\n", err)
 			}
 			return
+		} else if r.URL.Path == "/settings/feeds" {
+			c, err := r.Cookie("session")
+			if err == http.ErrNoCookie {
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+			sessionVar := c.Value
+			sess, err := loadSession(sessionVar, conn)
+			if err != nil {
+				log.Printf("ERROR: %s\n", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			if !isLoggedIn(h.Backend, &sess) {
+				w.WriteHeader(401)
+				fmt.Fprintf(w, "Unauthorized")
+				return
+			}
+
+			var page feedsPage
+			page.Session = sess
+			page.Feeds, err = h.Backend.getFeedHealth(r.Context())
+			if err != nil {
+				log.Printf("ERROR: %s\n", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			err = h.renderTemplate(w, "feeds.html", page)
+			if err != nil {
+				fmt.Fprintf(w, "ERROR: %s\n", err)
+			}
+			return
 		} else if r.URL.Path == "/logs" {
 			c, err := r.Cookie("session")
 			if err == http.ErrNoCookie {
@@ -493,7 +532,7 @@ func (h *mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 			var page settingsPage
 			page.Session = sess
-			page.Channels, err = h.Backend.ChannelsGetList(r.Context())
+			page.Channels, err = h.Backend.getChannels(r.Context())
 			if err != nil {
 				log.Printf("ERROR: %s\n", err)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -563,7 +602,7 @@ func (h *mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			page.RedirectURI = redirectURI
 			page.Scope = scope
 			page.State = state
-			page.Channels, err = h.Backend.ChannelsGetList(r.Context())
+			page.Channels, err = h.Backend.getChannels(r.Context())
 			if err != nil {
 				log.Printf("ERROR: %s\n", err)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -597,7 +636,8 @@ func (h *mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 			endpoints, err := getEndpoints(me)
 			if err != nil {
-				http.Error(w, fmt.Sprintf("Bad Request: %s, %s", err.Error(), me), http.StatusBadRequest)
+				log.Printf("could not get endpoints of %s: %v", me, err)
+				http.Error(w, "Bad Request: could not find the endpoints of your url", http.StatusBadRequest)
 				return
 			}
 
@@ -775,10 +815,46 @@ func (h *mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 
 			channelType := r.FormValue("type")
+			if channelType != "" && !isTimelineType(channelType) {
+				log.Println("type is not a timeline type", channelType)
+				http.Redirect(w, r, "/settings/channel?uid="+uid, http.StatusFound)
+				return
+			}
+
+			maxAge, err := parseRetention(r.FormValue("max_age"))
+			if err != nil {
+				log.Println("max_age is not a number", err)
+				http.Redirect(w, r, "/settings/channel?uid="+uid, http.StatusFound)
+				return
+			}
+
+			maxItems, err := parseRetention(r.FormValue("max_items"))
+			if err != nil {
+				log.Println("max_items is not a number", err)
+				http.Redirect(w, r, "/settings/channel?uid="+uid, http.StatusFound)
+				return
+			}
+
+			currentType := setting.ChannelType
+			if currentType == "" {
+				currentType = defaultTimelineType
+			}
+			if channelType != "" && channelType != currentType {
+				err = migrateTimeline(r.Context(), h.Backend, uid, channelType)
+				if err != nil {
+					log.Println("migrateTimeline", uid, channelType, err)
+					http.Redirect(w, r, "/settings/channel?uid="+uid, http.StatusFound)
+					return
+				}
+				setting.ChannelType = channelType
+			}
 
 			setting.ExcludeRegex = excludeRegex
 			setting.IncludeRegex = includeRegex
-			setting.ChannelType = channelType
+			setting.MaxAge = maxAge
+			setting.MaxItems = maxItems
+			setting.KeepUnread = r.FormValue("keep_unread") != ""
+			setting.KeepStarred = r.FormValue("keep_starred") != ""
 			if values, e := r.Form["exclude_type"]; e {
 				setting.ExcludeType = values
 			}
@@ -791,7 +867,7 @@ func (h *mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "/settings", http.StatusFound)
 			return
 		} else if r.URL.Path == "/refresh" {
-			h.Backend.RefreshFeeds()
+			h.Backend.RefreshFeeds(r.Context())
 			http.Redirect(w, r, "/", http.StatusFound)
 			return
 		}
@@ -800,6 +876,21 @@ func (h *mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.NotFound(w, r)
 }
 
+// parseRetention parses a retention setting from a form, an empty value is 0
+func parseRetention(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("retention %d is negative", n)
+	}
+	return n, nil
+}
+
 func httpSessionLogout(r *http.Request, w http.ResponseWriter, conn redis.Conn) {
 	c, err := r.Cookie("session")
 	if err == http.ErrNoCookie {
@@ -830,7 +921,7 @@ func getEndpoints(me string) (parsedEndpoints, error) {
 	}
 	endpoints.Me = meURL
 
-	eps, err := indieauth.GetEndpoints(meURL)
+	eps, err := indieauth.GetEndpoints(fetchClient, meURL)
 	if err != nil {
 		return endpoints, err
 	}
@@ -0,0 +1,112 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pstuifzand/ekster/pkg/auth"
+	"github.com/pstuifzand/ekster/pkg/fetch"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_mainHandler_loginRefusesLoopback(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Link", `<http://127.0.0.1/auth>; rel="authorization_endpoint"`)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) {
+		return nil, errors.New("no redis in this test")
+	}}
+	h := &mainHandler{BaseURL: "http://localhost/", pool: pool}
+
+	form := url.Values{}
+	form.Set("url", server.URL)
+	req := httptest.NewRequest(http.MethodPost, "/session", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "test-session"})
+	rec := httptest.NewRecorder()
+
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, 0, requests)
+	assert.NotContains(t, rec.Body.String(), server.URL)
+}
+
+func Test_getEndpoints_selfHosted(t *testing.T) {
+	// self-hosted endpoints on a private or loopback address are allowed
+	// with allow_addresses in the fetch policy
+	allowLoopback(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `<http://127.0.0.1/auth>; rel="authorization_endpoint"`)
+		w.Header().Add("Link", `<http://127.0.0.1/token>; rel="token_endpoint"`)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	endpoints, err := getEndpoints(server.URL)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "http://127.0.0.1/auth", endpoints.AuthorizationEndpoint.String())
+		assert.Equal(t, "http://127.0.0.1/token", endpoints.TokenEndpoint.String())
+	}
+}
+
+func Test_checkAuthToken_selfHosted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"me":"https://example.com/","scope":"read"}`)
+	}))
+	defer server.Close()
+
+	var token auth.TokenResponse
+	_, err := checkAuthToken("Bearer token", server.URL, &token)
+	assert.True(t, errors.Is(err, fetch.ErrBlockedAddress))
+
+	allowLoopback(t)
+	authorized, err := checkAuthToken("Bearer token", server.URL, &token)
+	assert.NoError(t, err)
+	assert.True(t, authorized)
+	assert.Equal(t, "https://example.com/", token.Me)
+}
+
+func Test_getAppInfo_refusesLoopback(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	_, err := getAppInfo(server.URL)
+	assert.True(t, errors.Is(err, fetch.ErrBlockedAddress))
+	assert.Equal(t, 0, requests)
+}
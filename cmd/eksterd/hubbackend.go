@@ -20,13 +20,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"expvar"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"net/http"
+	"strings"
 	"time"
 
 	"github.com/pstuifzand/ekster/pkg/util"
@@ -38,14 +39,22 @@ import (
 // LeaseSeconds is the default number of seconds we want the subscription to last
 const LeaseSeconds = 24 * 60 * 60
 
+// pendingVerificationTTL is how long a subscribe or unsubscribe request waits
+// for the verification by the hub
+const pendingVerificationTTL = 1 * time.Hour
+
 // HubBackend handles information for the incoming handler
 type HubBackend interface {
 	Feeds() ([]Feed, error)
 	CreateFeed(url string) (int64, error)
-	GetSecret(feedID int64) string
-	UpdateFeed(processor ContentProcessor, feedID int64, contentType string, body io.Reader) error
+	Subscription(feedID int64) (Feed, error)
+	SubscriptionDenied(feedID int64) error
+	UpdateFeed(ctx context.Context, processor ContentProcessor, feedID int64, contentType string, body io.Reader) error
 	FeedSetLeaseSeconds(feedID int64, leaseSeconds int64) error
 	Subscribe(feed *Feed) error
+	Subscriptions(topic string) ([]Feed, error)
+	Unsubscribe(subscriptions []Feed) error
+	Verify(mode string, feedID int64, topic string) bool
 }
 
 type hubIncomingBackend struct {
@@ -61,6 +70,7 @@ type Feed struct {
 	Callback      string
 	Hub           string
 	Secret        string
+	URLSecret     string
 	LeaseSeconds  int64
 	ResubscribeAt *time.Time
 }
@@ -73,17 +83,42 @@ func init() {
 	varWebsub = expvar.NewMap("websub")
 }
 
-func (h *hubIncomingBackend) GetSecret(id int64) string {
-	db := h.database
-	var secret string
-	err := db.QueryRow(
-		`select "subscription_secret" from "subscriptions" where "id" = $1`,
-		id,
-	).Scan(&secret)
+// Subscription returns the subscription with id
+func (h *hubIncomingBackend) Subscription(id int64) (Feed, error) {
+	feed := Feed{ID: id}
+	err := h.database.QueryRow(`
+select "topic", coalesce("hub", ''), coalesce("callback", ''), "subscription_secret", "url_secret", "lease_seconds", "resubscribe_at"
+from "subscriptions"
+where "id" = $1
+`, id).Scan(
+		&feed.URL,
+		&feed.Hub,
+		&feed.Callback,
+		&feed.Secret,
+		&feed.URLSecret,
+		&feed.LeaseSeconds,
+		&feed.ResubscribeAt,
+	)
 	if err != nil {
-		return ""
+		return feed, fmt.Errorf("while loading subscription %d: %w", id, err)
 	}
-	return secret
+	return feed, nil
+}
+
+// SubscriptionDenied stops resubscribing the subscription with id, after the
+// hub denied it
+func (h *hubIncomingBackend) SubscriptionDenied(id int64) error {
+	_, err := h.database.Exec(`update "subscriptions" set "hub" = null, "resubscribe_at" = null where "id" = $1`, id)
+	if err != nil {
+		return fmt.Errorf("while saving denied subscription %d: %w", id, err)
+	}
+	return nil
+}
+
+// callbackURL returns the callback URL of the subscription. The url secret
+// makes the URL hard to guess.
+func (h *hubIncomingBackend) callbackURL(feed Feed) string {
+	return fmt.Sprintf("%s/incoming/%d/%s", h.baseURL, feed.ID, feed.URLSecret)
 }
 
 func (h *hubIncomingBackend) CreateFeed(topic string) (int64, error) {
@@ -104,15 +139,13 @@ VALUES ($1, $2, $3, $4, DEFAULT) RETURNING "id"`, topic, secret, urlSecret, 60*6
 		return 0, fmt.Errorf("insert into subscriptions: %w", err)
 	}
 
-	client := &http.Client{}
-
-	hubURL, err := websub.GetHubURL(client, topic)
+	hubURL, err := websub.GetHubURL(fetchClient, topic)
 	if err != nil {
 		log.Printf("WebSub Hub URL not found for topic=%s\n", topic)
 		return 0, err
 	}
 
-	callbackURL := fmt.Sprintf("%s/incoming/%d", h.baseURL, subscriptionID)
+	callbackURL := h.callbackURL(Feed{ID: int64(subscriptionID), URLSecret: urlSecret})
 
 	log.Printf("WebSub Hub URL found for topic=%q hub=%q callback=%q\n", topic, hubURL, callbackURL)
 
@@ -125,7 +158,13 @@ VALUES ($1, $2, $3, $4, DEFAULT) RETURNING "id"`, topic, secret, urlSecret, 60*6
 		return int64(subscriptionID), nil
 	}
 
-	err = websub.Subscribe(client, hubURL, topic, callbackURL, secret, 24*3600)
+	err = h.Subscribe(&Feed{
+		ID:       int64(subscriptionID),
+		URL:      topic,
+		Hub:      hubURL,
+		Callback: callbackURL,
+		Secret:   secret,
+	})
 	if err != nil {
 		return 0, fmt.Errorf("subscribe: %w", err)
 	}
@@ -133,16 +172,17 @@ VALUES ($1, $2, $3, $4, DEFAULT) RETURNING "id"`, topic, secret, urlSecret, 60*6
 	return int64(subscriptionID), nil
 }
 
-func (h *hubIncomingBackend) UpdateFeed(processor ContentProcessor, subscriptionID int64, contentType string, body io.Reader) error {
+func (h *hubIncomingBackend) UpdateFeed(ctx context.Context, processor ContentProcessor, subscriptionID int64, contentType string, body io.Reader) error {
 	log.Println("UpdateFeed", subscriptionID)
 
 	db := h.database
 	// Process all channels that contains this feed
-	rows, err := db.Query(`
+	rows, err := db.QueryContext(ctx, `
 select topic, c.uid, f.id, c.name
 from subscriptions s
-inner join feeds f    on f.url = s.topic
-inner join channels c on c.id = f.channel_id
+inner join feeds f          on f.url = s.topic
+inner join channel_feeds cf on cf.feed_id = f.id
+inner join channels c       on c.id = cf.channel_id
 where s.id = $1
 `,
 		subscriptionID,
@@ -168,7 +208,7 @@ where s.id = $1
 		}
 
 		log.Printf("Updating feed %s %q in %q (%s)\n", feedID, topic, channelName, channel)
-		_, err = processor.ProcessContent(channel, feedID, topic, contentType, buf)
+		_, err = processor.ProcessContent(ctx, channel, feedID, topic, contentType, buf)
 		if err != nil {
 			log.Printf("could not process content for channel %s: %s", channelName, err)
 		}
@@ -197,11 +237,11 @@ func (h *hubIncomingBackend) Feeds() ([]Feed, error) {
 	var feeds []Feed
 
 	rows, err := db.Query(`
-		select s.id, topic, hub, callback, subscription_secret, lease_seconds, resubscribe_at
+		select s.id, topic, hub, coalesce(callback, ''), subscription_secret, url_secret, lease_seconds, resubscribe_at
 		from subscriptions s
 		inner join feeds f on f.url = s.topic
-		inner join channels c on c.id = f.channel_id
-		where hub is not null
+		where hub is not null and f.dead_at is null
+		and exists (select 1 from channel_feeds cf where cf.feed_id = f.id)
 	`)
 	if err != nil {
 		return nil, err
@@ -216,6 +256,7 @@ func (h *hubIncomingBackend) Feeds() ([]Feed, error) {
 			&feed.Hub,
 			&feed.Callback,
 			&feed.Secret,
+			&feed.URLSecret,
 			&feed.LeaseSeconds,
 			&feed.ResubscribeAt,
 		)
@@ -231,8 +272,145 @@ func (h *hubIncomingBackend) Feeds() ([]Feed, error) {
 
 func (h *hubIncomingBackend) Subscribe(feed *Feed) error {
 	log.Println("Subscribe", feed.URL)
-	client := http.Client{}
-	return websub.Subscribe(&client, feed.Hub, feed.URL, feed.Callback, feed.Secret, LeaseSeconds)
+	if err := h.expectVerification("subscribe", feed); err != nil {
+		return err
+	}
+	return websub.Subscribe(fetchClient, feed.Hub, feed.URL, feed.Callback, feed.Secret, LeaseSeconds)
+}
+
+// Subscriptions returns the subscriptions of topic at a hub. They should be
+// loaded before the feed of the topic is removed, because the subscriptions
+// are removed with it.
+func (h *hubIncomingBackend) Subscriptions(topic string) ([]Feed, error) {
+	rows, err := h.database.Query(`
+select "id", "hub", coalesce("callback", '')
+from "subscriptions"
+where "topic" = $1 and "hub" is not null
+`, topic)
+	if err != nil {
+		return nil, fmt.Errorf("while loading subscriptions of %s: %w", topic, err)
+	}
+	defer rows.Close()
+
+	var feeds []Feed
+	for rows.Next() {
+		feed := Feed{URL: topic}
+		if err := rows.Scan(&feed.ID, &feed.Hub, &feed.Callback); err != nil {
+			return nil, fmt.Errorf("while loading subscriptions of %s: %w", topic, err)
+		}
+		if feed.Callback == "" {
+			feed.Callback = fmt.Sprintf("%s/incoming/%d", h.baseURL, feed.ID)
+		}
+		feeds = append(feeds, feed)
+	}
+	return feeds, rows.Err()
+}
+
+// Unsubscribe asks the hubs of the subscriptions to stop sending updates. A
+// failure doesn't stop the other subscriptions from being unsubscribed, the
+// failures are returned together.
+func (h *hubIncomingBackend) Unsubscribe(subscriptions []Feed) error {
+	var failures []string
+	for _, feed := range subscriptions {
+		if err := h.unsubscribe(feed); err != nil {
+			log.Println(err)
+			varWebsub.Add("errors", 1)
+			failures = append(failures, err.Error())
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d unsubscribes failed: %s", len(failures), len(subscriptions), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// unsubscribe asks the hub of feed to stop sending updates to its callback
+func (h *hubIncomingBackend) unsubscribe(feed Feed) error {
+	if err := h.expectVerification("unsubscribe", &feed); err != nil {
+		return err
+	}
+
+	log.Printf("Send unsubscribe for %q on %q with callback %q\n", feed.URL, feed.Hub, feed.Callback)
+	varWebsub.Add("unsubscribe", 1)
+	err := websub.Unsubscribe(fetchClient, feed.Hub, feed.URL, feed.Callback)
+	if err != nil {
+		return fmt.Errorf("while unsubscribing %s from %s: %w", feed.URL, feed.Hub, err)
+	}
+	return nil
+}
+
+// expectVerification remembers that a request with mode ("subscribe" or
+// "unsubscribe") is sent for the subscription, because the hub verifies it
+func (h *hubIncomingBackend) expectVerification(mode string, feed *Feed) error {
+	conn := h.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SET", pendingVerificationKey(mode, feed.ID), feed.URL, "EX", int(pendingVerificationTTL.Seconds()))
+	if err != nil {
+		return fmt.Errorf("while saving %s of %s: %w", mode, feed.URL, err)
+	}
+	return nil
+}
+
+// Verify returns true when a request with mode for topic was sent for the
+// subscription feedID. A request is only verified once.
+func (h *hubIncomingBackend) Verify(mode string, feedID int64, topic string) bool {
+	conn := h.pool.Get()
+	defer conn.Close()
+
+	key := pendingVerificationKey(mode, feedID)
+	pending, err := redis.String(conn.Do("GET", key))
+	if err != nil || pending != topic {
+		return false
+	}
+	_, _ = conn.Do("DEL", key)
+	return true
+}
+
+func (h *hubIncomingBackend) setCallback(id int64, callback string) error {
+	_, err := h.database.Exec(`update "subscriptions" set "callback" = $1 where "id" = $2`, callback, id)
+	if err != nil {
+		return fmt.Errorf("while saving callback of subscription %d: %w", id, err)
+	}
+	return nil
+}
+
+// resubscribe renews the subscription of feed. Older subscriptions use a
+// callback without the url secret, they are moved to the callback with the url
+// secret, and the old callback is unsubscribed, so the hub stops sending
+// updates to it.
+func (h *hubIncomingBackend) resubscribe(feed Feed) error {
+	oldCallback := ""
+	if callback := h.callbackURL(feed); feed.Callback != callback {
+		if err := h.setCallback(feed.ID, callback); err != nil {
+			return err
+		}
+		oldCallback = feed.Callback
+		if oldCallback == "" {
+			oldCallback = fmt.Sprintf("%s/incoming/%d", h.baseURL, feed.ID)
+		}
+		feed.Callback = callback
+	}
+
+	log.Printf("Send resubscribe for %q on %q with callback %q\n", feed.URL, feed.Hub, feed.Callback)
+	varWebsub.Add("resubscribe", 1)
+	if err := h.Subscribe(&feed); err != nil {
+		return err
+	}
+
+	if oldCallback != "" {
+		old := feed
+		old.Callback = oldCallback
+		if err := h.unsubscribe(old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pendingVerificationKey(mode string, feedID int64) string {
+	return fmt.Sprintf("websub:%s:%d", mode, feedID)
 }
 
 func (h *hubIncomingBackend) run() error {
@@ -257,13 +435,7 @@ func (h *hubIncomingBackend) run() error {
 				for _, feed := range feeds {
 					log.Printf("Looking at %s\n", feed.URL)
 					if feed.ResubscribeAt != nil && time.Now().After(*feed.ResubscribeAt) {
-						if feed.Callback == "" {
-							feed.Callback = fmt.Sprintf("%s/incoming/%d", h.baseURL, feed.ID)
-						}
-						log.Printf("Send resubscribe for %q on %q with callback %q\n", feed.URL, feed.Hub, feed.Callback)
-						varWebsub.Add("resubscribe", 1)
-						err := h.Subscribe(&feed)
-						if err != nil {
+						if err := h.resubscribe(feed); err != nil {
 							log.Printf("Error while subscribing: %s", err)
 							varWebsub.Add("errors", 1)
 						}
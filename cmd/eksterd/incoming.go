@@ -20,25 +20,45 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/pstuifzand/ekster/pkg/websub"
 )
 
+// Notifier adds items to the notifications channel
+type Notifier interface {
+	Notify(ctx context.Context, name, text string)
+}
+
 type incomingHandler struct {
 	Backend   HubBackend
 	Processor ContentProcessor
+	Notifier  Notifier
 }
 
 var (
-	urlRegex = regexp.MustCompile(`^/incoming/(\d+)$`)
+	urlRegex = regexp.MustCompile(`^/incoming/(\d+)(?:/([a-zA-Z]+))?$`)
 )
 
+// validCallbackSecret returns true when secret from the callback URL is the
+// url secret of the subscription. Subscriptions with a callback without the
+// url secret are accepted without it, until they are resubscribed. Requests
+// that cancel a subscription always need the url secret.
+func validCallbackSecret(feed Feed, secret string) bool {
+	if secret == "" {
+		return !strings.HasSuffix(feed.Callback, "/"+feed.URLSecret)
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(feed.URLSecret)) == 1
+}
+
 func (h *incomingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
@@ -54,35 +74,109 @@ func (h *incomingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// find feed
 	matches := urlRegex.FindStringSubmatch(r.URL.Path)
+	if matches == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
 	feed, err := strconv.ParseInt(matches[1], 10, 64)
 	if err != nil {
-		fmt.Fprint(w, err)
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	values := r.URL.Query()
+
+	// The subscription is removed before the hub verifies the unsubscribe
+	if r.Method == http.MethodGet && values.Get("hub.mode") == "unsubscribe" {
+		if !h.Backend.Verify("unsubscribe", feed, values.Get("hub.topic")) {
+			log.Printf("unsubscribe of %q for feed %d was not requested", values.Get("hub.topic"), feed)
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		varWebsub.Add("unsubscribed", 1)
+		_, _ = fmt.Fprint(w, values.Get("hub.challenge"))
+		return
+	}
+
+	subscription, err := h.Backend.Subscription(feed)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if !validCallbackSecret(subscription, matches[2]) {
+		log.Printf("wrong url secret for feed %d", feed)
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
 	}
 
 	if r.Method == http.MethodGet {
-		values := r.URL.Query()
+		topic := values.Get("hub.topic")
+		if topic != subscription.URL {
+			log.Printf("verification of %q for feed %d does not match topic %q", topic, feed, subscription.URL)
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+
+		switch values.Get("hub.mode") {
+		case "subscribe":
+			if !h.Backend.Verify("subscribe", feed, topic) {
+				log.Printf("subscribe of %q for feed %d was not requested", topic, feed)
+				http.Error(w, "Not Found", http.StatusNotFound)
+				return
+			}
 
-		// check
-		if leaseStr := values.Get("hub.lease_seconds"); leaseStr != "" {
-			// update lease_seconds
+			if leaseStr := values.Get("hub.lease_seconds"); leaseStr != "" {
+				// update lease_seconds
+
+				leaseSeconds, err := strconv.ParseInt(leaseStr, 10, 64)
+				if err != nil {
+					log.Printf("error in hub.lease_seconds format %q: %s", leaseStr, err)
+					http.Error(w, fmt.Sprintf("error in hub.lease_seconds format %q: %s", leaseStr, err), http.StatusBadRequest)
+					return
+				}
+				err = h.Backend.FeedSetLeaseSeconds(feed, leaseSeconds)
+				if err != nil {
+					log.Printf("error in while setting hub.lease_seconds: %s", err)
+					http.Error(w, fmt.Sprintf("error in while setting hub.lease_seconds: %s", err), http.StatusBadRequest)
+					return
+				}
+			}
 
-			leaseSeconds, err := strconv.ParseInt(leaseStr, 10, 64)
-			if err != nil {
-				log.Printf("error in hub.lease_seconds format %q: %s", leaseSeconds, err)
-				http.Error(w, fmt.Sprintf("error in hub.lease_seconds format %q: %s", leaseSeconds, err), http.StatusBadRequest)
+			varWebsub.Add("subscribed", 1)
+			_, _ = fmt.Fprint(w, values.Get("hub.challenge"))
+
+		case "denied":
+			// Anyone could cancel a subscription with a callback without
+			// the url secret
+			if matches[2] == "" {
+				log.Printf("denial of %q for feed %d without url secret", topic, feed)
+				http.Error(w, "Not Found", http.StatusNotFound)
 				return
 			}
-			err = h.Backend.FeedSetLeaseSeconds(feed, leaseSeconds)
+
+			reason := values.Get("hub.reason")
+			log.Printf("subscription of %q for feed %d denied: %q", topic, feed, reason)
+			varWebsub.Add("denied", 1)
+
+			err := h.Backend.SubscriptionDenied(feed)
 			if err != nil {
-				log.Printf("error in while setting hub.lease_seconds: %s", err)
-				http.Error(w, fmt.Sprintf("error in while setting hub.lease_seconds: %s", err), http.StatusBadRequest)
+				log.Println(err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 				return
 			}
-		}
 
-		verify := values.Get("hub.challenge")
+			if h.Notifier != nil {
+				text := fmt.Sprintf("The hub %s denied the subscription to %s.", subscription.Hub, topic)
+				if reason != "" {
+					text += "\n\nReason: " + reason
+				}
+				h.Notifier.Notify(r.Context(), "WebSub subscription denied", text)
+			}
 
-		_, _ = fmt.Fprint(w, verify)
+		default:
+			http.Error(w, "Unknown hub.mode", http.StatusBadRequest)
+		}
 
 		return
 	}
@@ -92,11 +186,13 @@ func (h *incomingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// find secret
-	secret := h.Backend.GetSecret(feed)
+	// Every subscription is created with a secret, without it the content
+	// can't be verified
+	secret := subscription.Secret
 	if secret == "" {
-		log.Printf("missing secret for feed %d\n", feed)
-		http.Error(w, "Unknown", http.StatusBadRequest)
+		log.Printf("ERROR: subscription %d has no secret, content can't be verified\n", feed)
+		varWebsub.Add("rejected", 1)
+		http.Error(w, "subscription has no secret", http.StatusInternalServerError)
 		return
 	}
 
@@ -107,18 +203,23 @@ func (h *incomingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// match signature
+	// match signature, the hub signs all content because we sent a secret
 	sig := r.Header.Get("X-Hub-Signature")
-	if sig != "" {
-		if err := websub.ValidateHubSignature(sig, feedContent, []byte(secret)); err != nil {
-			log.Printf("could not validate signature: %+v", err)
-			http.Error(w, fmt.Sprintf("could not validate signature: %s", err), http.StatusBadRequest)
-			return
-		}
+	if sig == "" {
+		log.Printf("missing signature for feed %d\n", feed)
+		varWebsub.Add("rejected", 1)
+		http.Error(w, "missing signature", http.StatusBadRequest)
+		return
+	}
+	if err := websub.ValidateHubSignature(sig, feedContent, []byte(secret)); err != nil {
+		log.Printf("could not validate signature: %+v", err)
+		varWebsub.Add("rejected", 1)
+		http.Error(w, fmt.Sprintf("could not validate signature: %s", err), http.StatusBadRequest)
+		return
 	}
 
 	ct := r.Header.Get("Content-Type")
-	err = h.Backend.UpdateFeed(h.Processor, feed, ct, bytes.NewBuffer(feedContent))
+	err = h.Backend.UpdateFeed(r.Context(), h.Processor, feed, ct, bytes.NewBuffer(feedContent))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("could not update feed: %s (%s)", ct, err), http.StatusBadRequest)
 		return
@@ -0,0 +1,255 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHubBackend is a HubBackend for the tests of incomingHandler
+type fakeHubBackend struct {
+	subscriptions map[int64]Feed
+	// pending contains the topics of the requests that wait for the
+	// verification, by pendingVerificationKey
+	pending map[string]string
+	denied  []int64
+	updates []int64
+}
+
+func (f *fakeHubBackend) Feeds() ([]Feed, error)                    { return nil, nil }
+func (f *fakeHubBackend) CreateFeed(url string) (int64, error)      { return 0, nil }
+func (f *fakeHubBackend) FeedSetLeaseSeconds(feedID, s int64) error { return nil }
+func (f *fakeHubBackend) Subscribe(feed *Feed) error                { return nil }
+func (f *fakeHubBackend) Unsubscribe(subscriptions []Feed) error    { return nil }
+
+func (f *fakeHubBackend) Subscriptions(topic string) ([]Feed, error) {
+	return nil, nil
+}
+
+func (f *fakeHubBackend) UpdateFeed(ctx context.Context, processor ContentProcessor, feedID int64, contentType string, body io.Reader) error {
+	f.updates = append(f.updates, feedID)
+	return nil
+}
+
+func (f *fakeHubBackend) Subscription(feedID int64) (Feed, error) {
+	feed, ok := f.subscriptions[feedID]
+	if !ok {
+		return feed, sql.ErrNoRows
+	}
+	return feed, nil
+}
+
+func (f *fakeHubBackend) SubscriptionDenied(feedID int64) error {
+	f.denied = append(f.denied, feedID)
+	return nil
+}
+
+func (f *fakeHubBackend) Verify(mode string, feedID int64, topic string) bool {
+	key := pendingVerificationKey(mode, feedID)
+	if pending, ok := f.pending[key]; !ok || pending != topic {
+		return false
+	}
+	delete(f.pending, key)
+	return true
+}
+
+type fakeNotifier struct {
+	names []string
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, name, text string) {
+	n.names = append(n.names, name)
+}
+
+func newTestIncomingHandler() (*incomingHandler, *fakeHubBackend, *fakeNotifier) {
+	backend := &fakeHubBackend{subscriptions: map[int64]Feed{
+		1: {
+			ID:        1,
+			URL:       "https://example.com/feed",
+			Hub:       "https://hub.example.com/",
+			Callback:  "https://ekster.example.com/incoming/1/secret",
+			Secret:    "subscription secret",
+			URLSecret: "secret",
+		},
+		// subscribed before the callback contained the url secret
+		2: {
+			ID:        2,
+			URL:       "https://example.com/old",
+			Hub:       "https://hub.example.com/",
+			Callback:  "https://ekster.example.com/incoming/2",
+			URLSecret: "oldsecret",
+		},
+	}}
+	backend.pending = make(map[string]string)
+	notifier := &fakeNotifier{}
+	return &incomingHandler{Backend: backend, Notifier: notifier}, backend, notifier
+}
+
+func verifyIntent(h http.Handler, path, mode, topic string) *httptest.ResponseRecorder {
+	q := url.Values{}
+	q.Set("hub.mode", mode)
+	q.Set("hub.topic", topic)
+	q.Set("hub.challenge", "challenge")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path+"?"+q.Encode(), nil))
+	return w
+}
+
+func Test_incomingHandler_unknownPath(t *testing.T) {
+	h, _, _ := newTestIncomingHandler()
+	for _, path := range []string{"/incoming/", "/incoming/abc", "/incoming/1/", "/incoming/3/secret"} {
+		w := verifyIntent(h, path, "subscribe", "https://example.com/feed")
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+}
+
+func Test_incomingHandler_subscribe(t *testing.T) {
+	h, backend, _ := newTestIncomingHandler()
+
+	// the subscribe was not requested
+	w := verifyIntent(h, "/incoming/1/secret", "subscribe", "https://example.com/feed")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	backend.pending[pendingVerificationKey("subscribe", 1)] = "https://example.com/feed"
+	backend.pending[pendingVerificationKey("subscribe", 2)] = "https://example.com/old"
+
+	w = verifyIntent(h, "/incoming/1/secret", "subscribe", "https://example.com/other")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = verifyIntent(h, "/incoming/1/wrong", "subscribe", "https://example.com/feed")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = verifyIntent(h, "/incoming/1", "subscribe", "https://example.com/feed")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = verifyIntent(h, "/incoming/1/secret", "unknown", "https://example.com/feed")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = verifyIntent(h, "/incoming/1/secret", "subscribe", "https://example.com/feed")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "challenge", w.Body.String())
+
+	// the subscribe is only verified once
+	w = verifyIntent(h, "/incoming/1/secret", "subscribe", "https://example.com/feed")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// older callbacks without the url secret keep working
+	w = verifyIntent(h, "/incoming/2", "subscribe", "https://example.com/old")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "challenge", w.Body.String())
+}
+
+func Test_incomingHandler_unsubscribe(t *testing.T) {
+	h, backend, _ := newTestIncomingHandler()
+	backend.pending[pendingVerificationKey("unsubscribe", 3)] = "https://example.com/removed"
+
+	// the subscription is already removed
+	w := verifyIntent(h, "/incoming/3", "unsubscribe", "https://example.com/other")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = verifyIntent(h, "/incoming/3", "unsubscribe", "https://example.com/removed")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "challenge", w.Body.String())
+
+	// the unsubscribe is only verified once
+	w = verifyIntent(h, "/incoming/3", "unsubscribe", "https://example.com/removed")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_incomingHandler_denied(t *testing.T) {
+	h, backend, notifier := newTestIncomingHandler()
+
+	w := verifyIntent(h, "/incoming/1/secret", "denied", "https://example.com/other")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, backend.denied)
+
+	// a denial needs the url secret, also for older callbacks
+	w = verifyIntent(h, "/incoming/2", "denied", "https://example.com/old")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, backend.denied)
+
+	w = verifyIntent(h, "/incoming/1/secret", "denied", "https://example.com/feed")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []int64{1}, backend.denied)
+	assert.Equal(t, []string{"WebSub subscription denied"}, notifier.names)
+}
+
+func Test_incomingHandler_signature(t *testing.T) {
+	h, backend, _ := newTestIncomingHandler()
+
+	content := `<feed xmlns="http://www.w3.org/2005/Atom"></feed>`
+	mac := hmac.New(sha256.New, []byte("subscription secret"))
+	mac.Write([]byte(content))
+	signature := fmt.Sprintf("sha256=%x", mac.Sum(nil))
+
+	post := func(sig string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/incoming/1/secret", strings.NewReader(content))
+		r.Header.Set("Content-Type", "application/atom+xml")
+		if sig != "" {
+			r.Header.Set("X-Hub-Signature", sig)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	rejected := func() int64 {
+		if v, ok := varWebsub.Get("rejected").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := rejected()
+
+	w := post("")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = post("sha256=" + strings.Repeat("0", 64))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Empty(t, backend.updates)
+	assert.Equal(t, before+2, rejected())
+
+	w = post(signature)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []int64{1}, backend.updates)
+	assert.Equal(t, before+2, rejected())
+
+	// content for a subscription without a secret can't be verified
+	r := httptest.NewRequest(http.MethodPost, "/incoming/2", strings.NewReader(content))
+	r.Header.Set("X-Hub-Signature", signature)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, []int64{1}, backend.updates)
+	assert.Equal(t, before+3, rejected())
+}
@@ -22,6 +22,7 @@ Eksterd is a microsub server that is extendable.
 package main
 
 import (
+	"context"
 	"database/sql"
 	"embed"
 	_ "expvar"
@@ -51,8 +52,22 @@ type AppOptions struct {
 	RedisServer string
 	BaseURL     string
 	DatabaseURL string
-	pool        *redis.Pool
-	database    *sql.DB
+
+	// FetchWorkers is the number of feeds that are fetched at the same time
+	FetchWorkers int
+	// FetchHostInterval is the minimum time between requests to the same host
+	FetchHostInterval time.Duration
+	// FetchPolicy is the JSON file with the fetch policy, see fetch.Policy
+	FetchPolicy string
+	// FetchCache is the store of the fetch cache: redis, memory, disk or none
+	FetchCache string
+	// FetchCacheDir is the directory of the "disk" fetch cache
+	FetchCacheDir string
+	// FetchCacheSize is the maximum size in bytes of the "memory" and "disk" fetch cache
+	FetchCacheSize int64
+
+	pool     *redis.Pool
+	database *sql.DB
 }
 
 //go:embed db/migrations/*.sql
@@ -142,6 +157,12 @@ func main() {
 	flag.StringVar(&options.RedisServer, "redis", "redis:6379", "redis server")
 	flag.StringVar(&options.BaseURL, "baseurl", "", "http server baseurl")
 	flag.StringVar(&options.DatabaseURL, "db", "host=database user=postgres password=simple dbname=ekster sslmode=disable", "database url")
+	flag.IntVar(&options.FetchWorkers, "fetch-workers", defaultFetchWorkers, "number of feeds that are fetched at the same time")
+	flag.DurationVar(&options.FetchHostInterval, "fetch-host-interval", defaultFetchHostInterval, "minimum time between requests to the same host")
+	flag.StringVar(&options.FetchPolicy, "fetch-policy", "", "JSON file with the fetch policy (allowed and denied hosts, user agent and limits)")
+	flag.StringVar(&options.FetchCache, "fetch-cache", defaultFetchCache, "store of the fetch cache: redis, memory, disk or none")
+	flag.StringVar(&options.FetchCacheDir, "fetch-cache-dir", "", "directory of the disk fetch cache")
+	flag.Int64Var(&options.FetchCacheSize, "fetch-cache-size", defaultFetchCacheSize, "maximum size in bytes of the memory or disk fetch cache")
 
 	flag.Parse()
 
@@ -176,6 +197,22 @@ func main() {
 		log.Fatalf("database open failed: %s", err)
 	}
 	options.database = db
+
+	if flag.Arg(0) == "migrate-timeline" {
+		if flag.NArg() != 3 {
+			log.Fatal("usage: eksterd [options] migrate-timeline CHANNEL TYPE")
+		}
+		backend, err := loadMemoryBackend(pool, db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = migrateTimeline(context.Background(), backend, flag.Arg(1), flag.Arg(2))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	app, err := NewApp(options)
 	if err != nil {
 		log.Fatal(err)
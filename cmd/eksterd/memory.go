@@ -19,7 +19,6 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"database/sql"
@@ -37,6 +36,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lib/pq"
@@ -83,13 +83,65 @@ type memoryBackend struct {
 	pool *redis.Pool
 
 	database *sql.DB
+
+	// timelineType overrides the timeline type of all channels, see
+	// timeline.Create. When empty the type from the channel setting is used.
+	timelineType string
+
+	// fetchWorkers is the number of feeds RefreshFeeds fetches at the same time
+	fetchWorkers int
+	// hostLimiter spaces the requests of RefreshFeeds to the same host
+	hostLimiter *hostLimiter
+	// refreshing is 1 while RefreshFeeds runs
+	refreshing int32
+
+	// fetcher is used to fetch urls that are not feeds, see getFetcher
+	fetcher fetch.Fetcher
 }
 
+// defaultTimelineType is used when no timeline type is set
+const defaultTimelineType = "postgres-stream"
+
 type channelSetting struct {
 	ExcludeRegex string
 	IncludeRegex string
 	ExcludeType  []string
 	ChannelType  string
+
+	// MaxAge is the number of days items are kept, 0 keeps items forever
+	MaxAge int
+	// MaxItems is the number of items that are kept, 0 keeps all items
+	MaxItems int
+	// KeepUnread keeps unread items, even when they are older than MaxAge or
+	// not in the newest MaxItems items
+	KeepUnread bool
+	// KeepStarred keeps starred items, even when they are older than MaxAge or
+	// not in the newest MaxItems items
+	KeepStarred bool
+}
+
+// newChannelSetting returns the setting of a channel without saved settings.
+// Settings are loaded on top of it, so the defaults also apply to settings
+// that were saved before the option existed.
+func newChannelSetting() channelSetting {
+	return channelSetting{KeepStarred: true}
+}
+
+// pruneOptions returns the options to prune the channel at now, returns false
+// when the channel keeps all items
+func (setting channelSetting) pruneOptions(now time.Time) (timeline.PruneOptions, bool) {
+	if setting.MaxAge <= 0 && setting.MaxItems <= 0 {
+		return timeline.PruneOptions{}, false
+	}
+	opts := timeline.PruneOptions{
+		MaxItems:    setting.MaxItems,
+		KeepUnread:  setting.KeepUnread,
+		KeepStarred: setting.KeepStarred,
+	}
+	if setting.MaxAge > 0 {
+		opts.Before = now.AddDate(0, 0, -setting.MaxAge)
+	}
+	return opts, true
 }
 
 type channelMessage struct {
@@ -108,12 +160,52 @@ type newItemMessage struct {
 }
 
 type feed struct {
-	UID         string // channel
+	Channels    []string // uids of the channels that follow the feed
 	ID          int
 	URL         string
 	Tier        int
 	Unmodified  int
 	NextFetchAt time.Time
+
+	// ETag and LastModified are the validators of the last response, they
+	// are sent with the next request to make it conditional
+	ETag         string
+	LastModified string
+
+	// Failures is the number of fetches that failed in a row
+	Failures int
+	// LastStatus is the HTTP status of the last response, 0 when the fetch
+	// failed without a response
+	LastStatus int
+	// LastError is the error of the last failed fetch
+	LastError string
+}
+
+// updateTier moves the feed to a lower tier when it changed and to a higher
+// tier when it was unmodified twice, and sets the time of the next fetch.
+func (feed *feed) updateTier(changed bool, now time.Time) {
+	if changed {
+		feed.Tier--
+	} else {
+		feed.Unmodified++
+	}
+
+	if feed.Unmodified >= 2 {
+		feed.Tier++
+		feed.Unmodified = 0
+	}
+
+	if feed.Tier > 10 {
+		feed.Tier = 10
+	}
+
+	if feed.Tier < 0 {
+		feed.Tier = 0
+	}
+
+	minutes := time.Duration(math.Ceil(math.Exp2(float64(feed.Tier))))
+
+	feed.NextFetchAt = now.Add(minutes * time.Minute)
 }
 
 func (b *memoryBackend) AuthTokenAccepted(header string, r *auth.TokenResponse, endpoint string) (bool, error) {
@@ -132,25 +224,47 @@ func loadMemoryBackend(pool *redis.Pool, database *sql.DB) (*memoryBackend, erro
 	return backend, nil
 }
 
-// ChannelsGetList gets channels
+// ChannelsGetList gets channels, followed by the virtual channel with the
+// starred items
 func (b *memoryBackend) ChannelsGetList(ctx context.Context) ([]microsub.Channel, error) {
-	conn := b.pool.Get()
-	defer conn.Close()
+	channels, err := b.getChannels(ctx)
+	if err != nil {
+		return nil, err
+	}
 
+	saved, err := b.getUserTimeline(ctx, timeline.SavedChannel)
+	if err != nil {
+		return nil, err
+	}
+	count, err := saved.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	channels = append(channels, microsub.Channel{UID: timeline.SavedChannel, Name: "Saved", Unread: microsub.Unread{
+		Type:        microsub.UnreadCount,
+		UnreadCount: count,
+	}})
+
+	return channels, nil
+}
+
+// getChannels gets the channels of the user, without virtual channels
+func (b *memoryBackend) getChannels(ctx context.Context) ([]microsub.Channel, error) {
 	userID, _ := userid.FromContext(ctx)
 
 	var channels []microsub.Channel
 	rows, err := b.database.Query(`
 		SELECT c.uid, c.name, count(i.channel_id) as unread
-		FROM "channels" "c" left join items i on c.id = i.channel_id and i.is_read = 0
+		FROM "channels" "c" left join items i on c.id = i.channel_id and i.is_read = 0 and i.is_removed = 0
 		WHERE "c"."user_id" = $1
-		GROUP BY c.id;
+		GROUP BY c.id
+		ORDER BY CASE c.uid WHEN 'notifications' THEN 0 WHEN 'home' THEN 1 ELSE 2 END, c.priority, c.id;
 	`, userID)
 
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 	for rows.Next() {
 		var uid, name string
 		var count int
@@ -161,10 +275,26 @@ func (b *memoryBackend) ChannelsGetList(ctx context.Context) ([]microsub.Channel
 			UnreadCount: count,
 		}})
 	}
+	rows.Close()
+
+	// The unread counts from "items" are only right for postgres timelines
+	for i, channel := range channels {
+		timelineType, err := b.channelTimelineType(channel.UID)
+		if err != nil || timelineType == defaultTimelineType {
+			continue
+		}
+		tl := timeline.Create(ctx, channel.UID, timelineType, b.pool, b.database)
+		if tl == nil {
+			continue
+		}
+		count, err := tl.Count(ctx)
+		if err != nil {
+			log.Printf("while counting unread items of %s: %s", channel.UID, err)
+			continue
+		}
+		channels[i].Unread.UnreadCount = count
+	}
 
-	util.StablePartition(channels, 0, len(channels), func(i int) bool {
-		return channels[i].Unread.HasUnread()
-	})
 	return channels, nil
 }
 
@@ -200,10 +330,11 @@ func (b *memoryBackend) ChannelsCreate(ctx context.Context, name string) (micros
 		varMicrosub.Add("ChannelsCreate.RandStringBytes", 1)
 		channel.UID = util.RandStringBytes(24)
 		result, err := b.database.Exec(
-			`insert into "channels" ("uid", "name", "user_id", "created_at") values ($1, $2, $3, DEFAULT)`,
+			`insert into "channels" ("uid", "name", "user_id", "priority", "created_at") values ($1, $2, $3, $4, DEFAULT)`,
 			channel.UID,
 			channel.Name,
 			userID,
+			DefaultPrio,
 		)
 		if err != nil {
 			log.Println("channels insert", err)
@@ -248,34 +379,184 @@ func (b *memoryBackend) ChannelsDelete(ctx context.Context, uid string) error {
 	b.broker.Notifier <- sse.Message{Event: "delete channel", Object: channelDeletedMessage{1, uid}}
 	return nil
 }
+
+// ChannelsOrder changes the order of the channels. The "home" and
+// "notifications" channels always stay on top. Channels that are not in uids
+// keep their order after the channels in uids.
+func (b *memoryBackend) ChannelsOrder(ctx context.Context, uids []string) error {
+	userID, _ := userid.FromContext(ctx)
+
+	tx, err := b.database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	prio := 0
+	for _, uid := range uids {
+		if uid == "home" || uid == "notifications" {
+			continue
+		}
+		_, err = tx.Exec(`UPDATE "channels" SET "priority" = $1 WHERE "uid" = $2 AND "user_id" = $3`, prio, uid, userID)
+		if err != nil {
+			return fmt.Errorf("while ordering channel %s: %w", uid, err)
+		}
+		prio++
+	}
+
+	// The channels that are not in uids keep their order after the others
+	_, err = tx.Exec(`
+UPDATE "channels" SET "priority" = $1 + "r"."n"
+FROM (
+    SELECT "id", row_number() OVER (ORDER BY "priority", "id") - 1 AS "n"
+    FROM "channels"
+    WHERE "user_id" = $2 AND NOT ("uid" = ANY($3))
+) AS "r"
+WHERE "channels"."id" = "r"."id"
+`, prio, userID, pq.Array(uids))
+	if err != nil {
+		return fmt.Errorf("while ordering the other channels: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// updateFeed saves the feed after a successful fetch
 func (b *memoryBackend) updateFeed(feed feed) error {
 	_, err := b.database.Exec(`
 UPDATE "feeds"
-SET "tier" = $2, "unmodified" = $3, "next_fetch_at" = $4
+SET "tier" = $2, "unmodified" = $3, "next_fetch_at" = $4, "etag" = $5, "last_modified" = $6,
+    "last_status" = $7, "last_success_at" = now(), "consecutive_failures" = 0
 WHERE "id" = $1
-`, feed.ID, feed.Tier, feed.Unmodified, feed.NextFetchAt)
+`, feed.ID, feed.Tier, feed.Unmodified, feed.NextFetchAt, feed.ETag, feed.LastModified, feed.LastStatus)
 	return err
 }
 
+// updateFeedFailure saves the feed after a failed fetch, see feed.failed
+func (b *memoryBackend) updateFeedFailure(feed feed) error {
+	_, err := b.database.Exec(`
+UPDATE "feeds"
+SET "next_fetch_at" = $2, "last_status" = $3, "last_error" = $4, "last_error_at" = now(), "consecutive_failures" = $5
+WHERE "id" = $1
+`, feed.ID, feed.NextFetchAt, feed.LastStatus, feed.LastError, feed.Failures)
+	return err
+}
+
+// moveFeed changes the url of the feed to newURL after a permanent redirect.
+// When another feed already has newURL, the followers and items of the feed
+// are moved to that feed.
+func (b *memoryBackend) moveFeed(ctx context.Context, feed *feed, newURL string) error {
+	tx, err := b.database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	oldURL := feed.URL
+	isNewURL := false
+
+	// The subscriptions of the old url are removed below, the hubs are asked
+	// to stop sending updates after the commit
+	var subscriptions []Feed
+	if b.hubBackend != nil {
+		subscriptions, err = b.hubBackend.Subscriptions(oldURL)
+		if err != nil {
+			log.Printf("Error: %v", err)
+		}
+	}
+
+	var existingID int
+	err = tx.QueryRowContext(ctx, `SELECT "id" FROM "feeds" WHERE "url" = $1`, newURL).Scan(&existingID)
+	if err == sql.ErrNoRows {
+		isNewURL = true
+		// The topic of the WebSub subscription is updated with the url, but
+		// the new url can use another hub, so the subscription is created
+		// again below
+		_, err = tx.ExecContext(ctx, `UPDATE "feeds" SET "url" = $2 WHERE "id" = $1`, feed.ID, newURL)
+		if err != nil {
+			return fmt.Errorf("while updating url of feed %d: %w", feed.ID, err)
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM "subscriptions" WHERE "topic" = $1`, newURL)
+		if err != nil {
+			return fmt.Errorf("while removing subscriptions of %s: %w", newURL, err)
+		}
+	} else if err != nil {
+		return err
+	} else {
+		_, err = tx.ExecContext(ctx, `
+INSERT INTO "channel_feeds" ("channel_id", "feed_id")
+SELECT "channel_id", $2 FROM "channel_feeds" WHERE "feed_id" = $1
+ON CONFLICT ("channel_id", "feed_id") DO NOTHING
+`, feed.ID, existingID)
+		if err != nil {
+			return fmt.Errorf("while moving followers to feed %d: %w", existingID, err)
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE "items" SET "feed_id" = $2 WHERE "feed_id" = $1`, feed.ID, existingID)
+		if err != nil {
+			return fmt.Errorf("while moving items to feed %d: %w", existingID, err)
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM "feeds" WHERE "id" = $1`, feed.ID)
+		if err != nil {
+			return fmt.Errorf("while removing feed %d: %w", feed.ID, err)
+		}
+		feed.ID = existingID
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	feed.URL = newURL
+
+	if len(subscriptions) > 0 {
+		if err := b.hubBackend.Unsubscribe(subscriptions); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	}
+
+	if isNewURL && b.hubBackend != nil {
+		_, _ = b.hubBackend.CreateFeed(newURL)
+	}
+
+	b.addFeedNotice(ctx, "Feed moved", *feed, fmt.Sprintf("%s moved permanently to %s. The feed now uses the new url.", oldURL, newURL))
+	return nil
+}
+
+// markFeedDead stops fetching the feed, after the server answered that it is
+// gone. Following the feed again revives it.
+func (b *memoryBackend) markFeedDead(ctx context.Context, feed feed) error {
+	_, err := b.database.ExecContext(ctx, `UPDATE "feeds" SET "dead_at" = now(), "last_status" = $2 WHERE "id" = $1`, feed.ID, http.StatusGone)
+	if err != nil {
+		return fmt.Errorf("while marking feed %d as dead: %w", feed.ID, err)
+	}
+	b.addFeedNotice(ctx, "Feed is gone", feed, fmt.Sprintf("%s is gone (410 Gone) and is no longer fetched.", feed.URL))
+	return nil
+}
+
 func (b *memoryBackend) getFeeds() ([]feed, error) {
 	rows, err := b.database.Query(`
-SELECT "f"."id", "f"."url", "c"."uid", "f"."tier","f"."unmodified","f"."next_fetch_at"
+SELECT "f"."id", "f"."url", array_agg("c"."uid"), "f"."tier","f"."unmodified","f"."next_fetch_at","f"."etag","f"."last_modified","f"."consecutive_failures"
 FROM "feeds" AS "f"
-INNER JOIN public.channels c ON c.id = f.channel_id
-WHERE next_fetch_at IS NULL OR next_fetch_at < now()
+INNER JOIN "channel_feeds" AS "cf" ON "cf"."feed_id" = "f"."id"
+INNER JOIN "channels" AS "c" ON "c"."id" = "cf"."channel_id"
+WHERE ("f"."next_fetch_at" IS NULL OR "f"."next_fetch_at" < now()) AND "f"."dead_at" IS NULL
+GROUP BY "f"."id"
 `)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var feeds []feed
 	for rows.Next() {
 		var feedID int
-		var feedURL, UID string
+		var feedURL string
+		var channels []string
 		var tier, unmodified int
 		var nextFetchAt sql.NullTime
+		var etag, lastModified string
+		var failures int
 
-		err = rows.Scan(&feedID, &feedURL, &UID, &tier, &unmodified, &nextFetchAt)
+		err = rows.Scan(&feedID, &feedURL, pq.Array(&channels), &tier, &unmodified, &nextFetchAt, &etag, &lastModified, &failures)
 		if err != nil {
 			log.Printf("while scanning feeds: %s", err)
 			continue
@@ -291,42 +572,157 @@ WHERE next_fetch_at IS NULL OR next_fetch_at < now()
 		feeds = append(
 			feeds,
 			feed{
-				UID:         UID,
-				ID:          feedID,
-				URL:         feedURL,
-				Tier:        tier,
-				Unmodified:  unmodified,
-				NextFetchAt: fetchTime,
+				Channels:     channels,
+				ID:           feedID,
+				URL:          feedURL,
+				Tier:         tier,
+				Unmodified:   unmodified,
+				NextFetchAt:  fetchTime,
+				ETag:         etag,
+				LastModified: lastModified,
+				Failures:     failures,
 			},
 		)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	return feeds, nil
 }
 
+// pruneInterval is the time between runs of PruneChannels
+const pruneInterval = 1 * time.Hour
+
 func (b *memoryBackend) run() {
 	b.ticker = time.NewTicker(1 * time.Minute)
 	b.quit = make(chan struct{})
 
 	go func() {
-		b.RefreshFeeds()
+		b.RefreshFeeds(context.Background())
 
 		for {
 			select {
 			case <-b.ticker.C:
-				b.RefreshFeeds()
+				b.RefreshFeeds(context.Background())
 			case <-b.quit:
 				b.ticker.Stop()
 				return
 			}
 		}
 	}()
+
+	go func() {
+		pruneTicker := time.NewTicker(pruneInterval)
+		defer pruneTicker.Stop()
+
+		for {
+			select {
+			case <-pruneTicker.C:
+				b.PruneChannels(context.Background())
+			case <-b.quit:
+				return
+			}
+		}
+	}()
+}
+
+// PruneChannels deletes the old items of all channels, as selected by the
+// retention settings of the channel
+func (b *memoryBackend) PruneChannels(ctx context.Context) {
+	rows, err := b.database.QueryContext(ctx, `
+SELECT "c"."uid", "s"."settings"
+FROM "channels" AS "c"
+INNER JOIN "channel_settings" AS "s" ON "s"."channel_id" = "c"."id"
+`)
+	if err != nil {
+		log.Printf("while loading channel settings for pruning: %v", err)
+		return
+	}
+
+	settings := make(map[string]channelSetting)
+	for rows.Next() {
+		var uid string
+		setting := newChannelSetting()
+		if err := rows.Scan(&uid, &setting); err != nil {
+			log.Printf("while loading channel settings for pruning: %v", err)
+			continue
+		}
+		settings[uid] = setting
+	}
+	rows.Close()
+
+	for uid, setting := range settings {
+		n, err := b.pruneChannel(ctx, uid, setting)
+		if err != nil {
+			log.Printf("while pruning %s: %v", uid, err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Pruned %d items from %s", n, uid)
+		}
+	}
+}
+
+// pruneChannel deletes the old items from the channel and the search index,
+// and returns the number of deleted items
+func (b *memoryBackend) pruneChannel(ctx context.Context, channel string, setting channelSetting) (int, error) {
+	opts, ok := setting.pruneOptions(time.Now())
+	if !ok {
+		return 0, nil
+	}
+
+	tl, err := b.getTimeline(ctx, channel)
+	if err != nil {
+		return 0, err
+	}
+
+	pruner, ok := tl.(timeline.Pruner)
+	if !ok {
+		return 0, nil
+	}
+
+	uids, err := pruner.Prune(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, uid := range uids {
+		if err = removeFromSearch(channel, uid); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if len(uids) > 0 {
+		if err = b.updateChannelUnreadCount(ctx, channel); err != nil {
+			return len(uids), err
+		}
+	}
+
+	return len(uids), nil
 }
 
-func (b *memoryBackend) RefreshFeeds() {
+// RefreshFeeds fetches the feeds that are due, with fetchWorkers feeds at the
+// same time. When RefreshFeeds is already running, it returns immediately.
+func (b *memoryBackend) RefreshFeeds(ctx context.Context) {
+	if !atomic.CompareAndSwapInt32(&b.refreshing, 0, 1) {
+		log.Println("Feed update process already running")
+		varMicrosub.Add("RefreshFeeds.skipped", 1)
+		return
+	}
+	defer atomic.StoreInt32(&b.refreshing, 0)
+
 	log.Println("Feed update process started")
 	defer log.Println("Feed update process completed")
 
+	varMicrosub.Add("RefreshFeeds.runs", 1)
+	start := time.Now()
+	defer func() {
+		var duration expvar.Float
+		duration.Set(time.Since(start).Seconds())
+		varMicrosub.Set("RefreshFeeds.lastDuration", &duration)
+	}()
+
 	feeds, err := b.getFeeds()
 	if err != nil {
 		return
@@ -334,76 +730,182 @@ func (b *memoryBackend) RefreshFeeds() {
 
 	log.Printf("Found %d feeds", len(feeds))
 
-	count := 0
-	for _, feed := range feeds {
-		log.Println("Processing", feed.URL)
-		err := b.refreshFeed(feed)
-		if err != nil {
-			b.addNotification("Error while fetching feed", feed, err)
-			continue
-		}
+	workers := b.fetchWorkers
+	if workers <= 0 {
+		workers = defaultFetchWorkers
+	}
+
+	jobs := make(chan feed)
+	var count int64
+	var wg sync.WaitGroup
+
+	// unhealthy contains the feeds that became unhealthy in this run
+	var unhealthy []feed
+	var unhealthyLock sync.Mutex
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for feed := range jobs {
+				log.Println("Processing", feed.URL)
+				varMicrosub.Add("RefreshFeeds.active", 1)
+				err := b.refreshFeed(ctx, feed)
+				varMicrosub.Add("RefreshFeeds.active", -1)
+				if err != nil {
+					varMicrosub.Add("RefreshFeeds.errors", 1)
+					log.Printf("Error: while refreshing %s: %v", feed.URL, err)
+					feed.failed(err, time.Now())
+					if err := b.updateFeedFailure(feed); err != nil {
+						log.Printf("Error: while updating feed %v: %v", feed, err)
+					}
+					if feed.Failures == unhealthyFailures {
+						unhealthyLock.Lock()
+						unhealthy = append(unhealthy, feed)
+						unhealthyLock.Unlock()
+					}
+					continue
+				}
+
+				varMicrosub.Add("RefreshFeeds.feeds", 1)
+				atomic.AddInt64(&count, 1)
+			}
+		}()
+	}
 
-		count++
+	if err := b.hostLimiter.dispatch(ctx, feeds, jobs); err != nil {
+		log.Printf("Error: while refreshing feeds: %v", err)
 	}
+	close(jobs)
+	wg.Wait()
 
-	if count > 0 {
-		_ = b.updateChannelUnreadCount("notifications")
+	if len(unhealthy) > 0 {
+		b.addUnhealthyFeedsNotice(ctx, unhealthy)
+	}
+
+	if count > 0 || len(unhealthy) > 0 {
+		_ = b.updateChannelUnreadCount(ctx, "notifications")
 	}
 	log.Printf("Processed %d feeds", count)
 }
 
-func (b *memoryBackend) refreshFeed(feed feed) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (b *memoryBackend) refreshFeed(ctx context.Context, feed feed) error {
+	fetchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	resp, err := b.Fetch3(ctx, feed.UID, feed.URL)
+	log.Printf("Fetching channel=%s fetchURL=%s\n", strings.Join(feed.Channels, ","), feed.URL)
+	resp, err := fetchIfModified(fetchCtx, feed.URL, feed.ETag, feed.LastModified)
 	if err != nil {
-		return fmt.Errorf("while Fetch3 of %s: %w", feed.URL, err)
+		return fmt.Errorf("while fetching %s: %w", feed.URL, err)
 	}
 	defer resp.Body.Close()
 
-	changed, err := b.ProcessContent(feed.UID, fmt.Sprintf("%d", feed.ID), feed.URL, resp.Header.Get("Content-Type"), resp.Body)
-	if err != nil {
-		return fmt.Errorf("in ProcessContent of %s: %w", feed.URL, err)
+	if movedTo, ok := permanentRedirect(resp); ok && movedTo != feed.URL {
+		log.Printf("Moved permanently %s to %s", feed.URL, movedTo)
+		if err := b.moveFeed(ctx, &feed, movedTo); err != nil {
+			log.Printf("Error: while moving feed %s to %s: %v", feed.URL, movedTo, err)
+		}
 	}
 
-	if changed {
-		feed.Tier--
-	} else {
-		feed.Unmodified++
-	}
+	now := time.Now()
+	changed := false
+	hint := responseFetchHint(resp, now)
+
+	feed.LastStatus = resp.StatusCode
+
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		log.Printf("Gone %s", feed.URL)
+		return b.markFeedDead(ctx, feed)
+	case resp.StatusCode == http.StatusNotModified:
+		log.Printf("Not modified %s", feed.URL)
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusServiceUnavailable:
+		log.Printf("Server busy %s: %s", feed.URL, resp.Status)
+		return &statusError{StatusCode: resp.StatusCode, Status: resp.Status, RetryAfter: hint}
+	case resp.StatusCode >= 400:
+		return &statusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	default:
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("while reading %s: %w", feed.URL, err)
+		}
 
-	if feed.Unmodified >= 2 {
-		feed.Tier++
-		feed.Unmodified = 0
-	}
+		items, interval, err := processSourcedItems(b.getFetcher(), feed.URL, resp.Header.Get("Content-Type"), bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("in ProcessContent of %s: %w", feed.URL, err)
+		}
 
-	if feed.Tier > 10 {
-		feed.Tier = 10
-	}
+		// The feed is fetched and parsed once and added to all channels that
+		// follow it
+		for _, channel := range feed.Channels {
+			added, err := b.addFeedItems(ctx, channel, fmt.Sprintf("%d", feed.ID), items)
+			if err != nil {
+				return fmt.Errorf("in ProcessContent of %s: %w", feed.URL, err)
+			}
+			changed = changed || added
+		}
 
-	if feed.Tier < 0 {
-		feed.Tier = 0
-	}
+		feed.ETag = resp.Header.Get("ETag")
+		feed.LastModified = resp.Header.Get("Last-Modified")
 
-	minutes := time.Duration(math.Ceil(math.Exp2(float64(feed.Tier))))
+		hint = fetchHint(hint, interval)
+	}
 
-	feed.NextFetchAt = time.Now().Add(minutes * time.Minute)
+	feed.updateTier(changed, now)
+	feed.applyFetchHint(hint, now)
 
-	log.Printf("Next Fetch in %d minutes at %v", minutes, feed.NextFetchAt.Format(time.RFC3339))
+	log.Printf("Next Fetch at %v", feed.NextFetchAt.Format(time.RFC3339))
 
 	err = b.updateFeed(feed)
 	if err != nil {
 		log.Printf("Error: while updating feed %v: %v", feed, err)
-		// don't return error, because it becomes a notification
+		// don't return error, because the fetch itself succeeded
 		return nil
 	}
 
 	return nil
 }
 
-func (b *memoryBackend) addNotification(name string, feed feed, err error) {
-	_, err = b.channelAddItem("notifications", microsub.Item{
+func (b *memoryBackend) addNotification(ctx context.Context, name string, feed feed, err error) {
+	b.addFeedNotice(ctx, name, feed, fmt.Sprintf("ERROR: while updating feed: %s", err))
+}
+
+// addUnhealthyFeedsNotice adds one item to the notifications channel for all
+// feeds that failed unhealthyFailures times in a row
+func (b *memoryBackend) addUnhealthyFeedsNotice(ctx context.Context, feeds []feed) {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%d feeds failed %d times in a row and are fetched less often:\n\n", len(feeds), unhealthyFailures)
+	for _, feed := range feeds {
+		fmt.Fprintf(&text, "%s: %s\n", feed.URL, feed.LastError)
+	}
+	text.WriteString("\nThe status of all feeds is shown at /settings/feeds.")
+
+	b.addNotice(ctx, "Feeds are failing", text.String())
+}
+
+// Notify adds an item to the notifications channel and updates its unread
+// count
+func (b *memoryBackend) Notify(ctx context.Context, name, text string) {
+	b.addNotice(ctx, name, text)
+	_ = b.updateChannelUnreadCount(ctx, "notifications")
+}
+
+// addNotice adds an item to the notifications channel
+func (b *memoryBackend) addNotice(ctx context.Context, name, text string) {
+	_, err := b.channelAddItem(ctx, "notifications", microsub.Item{
+		Type:      "entry",
+		Name:      name,
+		Content:   &microsub.Content{Text: text},
+		Published: time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Printf("ERROR: %s", err)
+	}
+}
+
+// addFeedNotice adds an item about the feed to the notifications channel
+func (b *memoryBackend) addFeedNotice(ctx context.Context, name string, feed feed, text string) {
+	_, err := b.channelAddItem(ctx, "notifications", microsub.Item{
 		Type: "entry",
 		Source: &microsub.Source{
 			ID:   strconv.Itoa(feed.ID),
@@ -412,7 +914,7 @@ func (b *memoryBackend) addNotification(name string, feed feed, err error) {
 		},
 		Name: name,
 		Content: &microsub.Content{
-			Text: fmt.Sprintf("ERROR: while updating feed: %s", err),
+			Text: text,
 		},
 		Published: time.Now().Format(time.RFC3339),
 	})
@@ -421,104 +923,530 @@ func (b *memoryBackend) addNotification(name string, feed feed, err error) {
 	}
 }
 
-func (b *memoryBackend) TimelineGet(ctx context.Context, before, after, channel string) (microsub.Timeline, error) {
-	log.Printf("TimelineGet %s\n", channel)
-
-	// Check if feed exists
-	_, err := b.FollowGetList(ctx, channel)
-	if err != nil {
-		return microsub.Timeline{Items: []microsub.Item{}}, err
+func (b *memoryBackend) TimelineGet(ctx context.Context, before, after, channel string, filter microsub.TimelineFilter) (microsub.Timeline, error) {
+	log.Printf("TimelineGet %s\n", channel)
+
+	// Check if feed exists
+	_, err := b.FollowGetList(ctx, channel)
+	if err != nil {
+		return microsub.Timeline{Items: []microsub.Item{}}, err
+	}
+
+	timelineBackend, err := b.getUserTimeline(ctx, channel)
+	if err != nil {
+		return microsub.Timeline{}, err
+	}
+
+	// _ = b.updateChannelUnreadCount(ctx, channel)
+
+	return timelineBackend.Items(ctx, before, after, filter)
+}
+
+func (b *memoryBackend) FollowGetList(ctx context.Context, uid string) ([]microsub.Feed, error) {
+	rows, err := b.database.Query(`
+SELECT "f"."url", `+feedStatusColumns+`
+FROM "feeds" AS "f"
+INNER JOIN "channel_feeds" AS "cf" ON "cf"."feed_id" = "f"."id"
+INNER JOIN "channels" AS "c" ON "c"."id" = "cf"."channel_id"
+WHERE "c"."uid" = $1
+ORDER BY "cf"."id"
+`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feeds []microsub.Feed
+	for rows.Next() {
+		var feedURL string
+		var status feedStatusRow
+		err = rows.Scan(append([]interface{}{&feedURL}, status.dest()...)...)
+		if err != nil {
+			continue
+		}
+		feeds = append(feeds, microsub.Feed{
+			Type:   "feed",
+			URL:    feedURL,
+			Status: status.status(),
+		})
+	}
+	return feeds, nil
+}
+
+// feedStatusColumns are the columns of "feeds" that are scanned into a
+// feedStatusRow
+const feedStatusColumns = `"f"."last_success_at", "f"."last_error_at", "f"."last_error", "f"."consecutive_failures", "f"."last_status", "f"."dead_at"`
+
+type feedStatusRow struct {
+	lastSuccessAt sql.NullTime
+	lastErrorAt   sql.NullTime
+	lastError     string
+	failures      int
+	lastStatus    int
+	deadAt        sql.NullTime
+}
+
+func (row *feedStatusRow) dest() []interface{} {
+	return []interface{}{&row.lastSuccessAt, &row.lastErrorAt, &row.lastError, &row.failures, &row.lastStatus, &row.deadAt}
+}
+
+func (row *feedStatusRow) status() *microsub.FeedStatus {
+	status := &microsub.FeedStatus{
+		LastError:           row.lastError,
+		ConsecutiveFailures: row.failures,
+		LastStatus:          row.lastStatus,
+		Dead:                row.deadAt.Valid,
+	}
+	if row.lastSuccessAt.Valid {
+		status.LastSuccess = row.lastSuccessAt.Time.Format(time.RFC3339)
+	}
+	if row.lastErrorAt.Valid {
+		status.LastErrorAt = row.lastErrorAt.Time.Format(time.RFC3339)
+	}
+	return status
+}
+
+// feedHealth is a feed of the user with the names of the channels that follow
+// it
+type feedHealth struct {
+	microsub.Feed
+	Channels []string
+}
+
+// getFeedHealth returns the feeds of the user, dead and failing feeds first
+func (b *memoryBackend) getFeedHealth(ctx context.Context) ([]feedHealth, error) {
+	userID, _ := userid.FromContext(ctx)
+
+	rows, err := b.database.QueryContext(ctx, `
+SELECT "f"."url", array_agg("c"."name" ORDER BY "c"."priority", "c"."id"), `+feedStatusColumns+`
+FROM "feeds" AS "f"
+INNER JOIN "channel_feeds" AS "cf" ON "cf"."feed_id" = "f"."id"
+INNER JOIN "channels" AS "c" ON "c"."id" = "cf"."channel_id"
+WHERE "c"."user_id" = $1
+GROUP BY "f"."id"
+ORDER BY "f"."dead_at" IS NULL, "f"."consecutive_failures" DESC, "f"."url"
+`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("while querying feed health: %w", err)
+	}
+	defer rows.Close()
+
+	var feeds []feedHealth
+	for rows.Next() {
+		var feed feedHealth
+		var status feedStatusRow
+		err = rows.Scan(append([]interface{}{&feed.URL, pq.Array(&feed.Channels)}, status.dest()...)...)
+		if err != nil {
+			return nil, fmt.Errorf("while scanning feed health: %w", err)
+		}
+		feed.Type = "feed"
+		feed.Status = status.status()
+		feeds = append(feeds, feed)
+	}
+	return feeds, rows.Err()
+}
+
+func (b *memoryBackend) FollowURL(ctx context.Context, uid string, url string) (microsub.Feed, error) {
+	subFeed := microsub.Feed{Type: "feed", URL: url}
+
+	var channelID int
+	err := b.database.QueryRow(`SELECT "id" FROM "channels" WHERE "uid" = $1`, uid).Scan(&channelID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return microsub.Feed{}, fmt.Errorf("channel does not exist: %w", err)
+		}
+		return microsub.Feed{}, err
+	}
+
+	// Feeds are shared between channels, a new feed is only created for the
+	// first follower
+	isNewFeed := true
+	var feedID int
+	err = b.database.QueryRow(
+		`INSERT INTO "feeds" ("url", "tier", "unmodified", "next_fetch_at") VALUES ($1, 1, 0, now()) ON CONFLICT ("url") DO NOTHING RETURNING "id"`,
+		subFeed.URL,
+	).Scan(&feedID)
+	if err == sql.ErrNoRows {
+		isNewFeed = false
+		err = b.database.QueryRow(`UPDATE "feeds" SET "dead_at" = NULL WHERE "url" = $1 RETURNING "id"`, subFeed.URL).Scan(&feedID)
+	}
+	if err != nil {
+		return subFeed, err
+	}
+
+	_, err = b.database.Exec(
+		`INSERT INTO "channel_feeds" ("channel_id", "feed_id") VALUES ($1, $2) ON CONFLICT ("channel_id", "feed_id") DO NOTHING`,
+		channelID,
+		feedID,
+	)
+	if err != nil {
+		return subFeed, err
+	}
+
+	var newFeed = feed{
+		ID:          feedID,
+		Channels:    []string{uid},
+		URL:         url,
+		Tier:        1,
+		Unmodified:  0,
+		NextFetchAt: time.Now(),
+	}
+	resp, err := b.Fetch3(ctx, uid, subFeed.URL)
+	if err != nil {
+		log.Println(err)
+		b.addNotification(ctx, "Error while fetching feed", newFeed, err)
+		_ = b.updateChannelUnreadCount(ctx, "notifications")
+		return subFeed, err
+	}
+	defer resp.Body.Close()
+
+	_, _ = b.ProcessContent(ctx, uid, fmt.Sprintf("%d", feedID), subFeed.URL, resp.Header.Get("Content-Type"), resp.Body)
+
+	if isNewFeed {
+		_, _ = b.hubBackend.CreateFeed(url)
+	}
+
+	return subFeed, nil
+}
+
+func (b *memoryBackend) UnfollowURL(ctx context.Context, uid string, url string) error {
+	tx, err := b.database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var channelID, feedID int
+	err = tx.QueryRowContext(ctx, `
+DELETE FROM "channel_feeds" "cf"
+USING "channels" "c", "feeds" "f"
+WHERE "c"."id" = "cf"."channel_id" AND "f"."id" = "cf"."feed_id" AND "f"."url" = $1 AND "c"."uid" = $2
+RETURNING "cf"."channel_id", "cf"."feed_id"
+`, url, uid).Scan(&channelID, &feedID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("while unfollowing %s: %w", url, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM "items" WHERE "channel_id" = $1 AND "feed_id" = $2`, channelID, feedID)
+	if err != nil {
+		return fmt.Errorf("while removing items of %s: %w", url, err)
+	}
+
+	var followers int
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM "channel_feeds" WHERE "feed_id" = $1`, feedID).Scan(&followers)
+	if err != nil {
+		return fmt.Errorf("while counting followers of %s: %w", url, err)
+	}
+	// The subscriptions are removed with the feed, the hubs are asked to
+	// stop sending updates after the commit
+	var subscriptions []Feed
+	if followers == 0 && b.hubBackend != nil {
+		subscriptions, err = b.hubBackend.Subscriptions(url)
+		if err != nil {
+			log.Printf("Error: %v", err)
+		}
+	}
+
+	// Remove the feed when the last follower is gone
+	_, err = tx.ExecContext(ctx, `
+DELETE FROM "feeds" "f"
+WHERE "f"."id" = $1 AND NOT EXISTS (SELECT 1 FROM "channel_feeds" "cf" WHERE "cf"."feed_id" = "f"."id")
+`, feedID)
+	if err != nil {
+		return fmt.Errorf("while removing feed %s: %w", url, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if len(subscriptions) > 0 {
+		if err := b.hubBackend.Unsubscribe(subscriptions); err != nil {
+			log.Printf("Error: %v", err)
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) MuteGetList(ctx context.Context, uid string) ([]microsub.Card, error) {
+	channelID, err := b.userChannelID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := b.database.Query(`SELECT "url" FROM "mutes" WHERE "channel_id" = $1 ORDER BY "created_at"`, channelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	muted := []microsub.Card{}
+	for rows.Next() {
+		var mutedURL string
+		err = rows.Scan(&mutedURL)
+		if err != nil {
+			continue
+		}
+		muted = append(muted, microsub.Card{
+			Type: "card",
+			URL:  mutedURL,
+		})
+	}
+	return muted, nil
+}
+
+func (b *memoryBackend) Mute(ctx context.Context, uid string, url string) error {
+	if url == "" {
+		return fmt.Errorf("missing url to mute")
+	}
+
+	channelID, err := b.userChannelID(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.database.Exec(
+		`INSERT INTO "mutes" ("channel_id", "url", "created_at") VALUES ($1, $2, DEFAULT) ON CONFLICT ("channel_id", "url") DO NOTHING`,
+		channelID,
+		url,
+	)
+	return err
+}
+
+func (b *memoryBackend) Unmute(ctx context.Context, uid string, url string) error {
+	channelID, err := b.userChannelID(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.database.Exec(`DELETE FROM "mutes" WHERE "channel_id" = $1 AND "url" = $2`, channelID, url)
+	return err
+}
+
+// userChannelID returns the id of the channel with uid owned by the user in ctx
+func (b *memoryBackend) userChannelID(ctx context.Context, uid string) (int, error) {
+	userID, _ := userid.FromContext(ctx)
+	var channelID int
+	err := b.database.QueryRow(`SELECT "id" FROM "channels" WHERE "uid" = $1 AND "user_id" = $2`, uid, userID).Scan(&channelID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("channel %q: %w", uid, microsub.ErrChannelNotFound)
+	}
+	return channelID, err
+}
+
+// isMuted returns true when the author of the item is muted in the channel
+func (b *memoryBackend) isMuted(channel string, item microsub.Item) (bool, error) {
+	if item.Author == nil || item.Author.URL == "" {
+		return false, nil
 	}
 
-	timelineBackend, err := b.getTimeline(channel)
+	var count int
+	err := b.database.QueryRow(
+		`SELECT COUNT(*) FROM "mutes" "m" INNER JOIN "channels" "c" ON "c"."id" = "m"."channel_id" WHERE "c"."uid" = $1 AND "m"."url" = $2`,
+		channel,
+		item.Author.URL,
+	).Scan(&count)
 	if err != nil {
-		return microsub.Timeline{}, err
+		return false, err
 	}
-
-	// _ = b.updateChannelUnreadCount(channel)
-
-	return timelineBackend.Items(before, after)
+	return count > 0, nil
 }
 
-func (b *memoryBackend) FollowGetList(ctx context.Context, uid string) ([]microsub.Feed, error) {
-	rows, err := b.database.Query(`SELECT "f"."url" FROM "feeds" AS "f" INNER JOIN channels c on c.id = f.channel_id WHERE c.uid = $1`, uid)
+func (b *memoryBackend) BlockGetList(ctx context.Context) ([]microsub.Card, error) {
+	userID, _ := userid.FromContext(ctx)
+
+	rows, err := b.database.Query(`SELECT "url" FROM "blocks" WHERE "user_id" = $1 ORDER BY "created_at"`, userID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	var feeds []microsub.Feed
+	blocked := []microsub.Card{}
 	for rows.Next() {
-		var feedURL string
-		err = rows.Scan(&feedURL)
+		var blockedURL string
+		err = rows.Scan(&blockedURL)
 		if err != nil {
 			continue
 		}
-		feeds = append(feeds, microsub.Feed{
-			Type: "feed",
-			URL:  feedURL,
+		blocked = append(blocked, microsub.Card{
+			Type: "card",
+			URL:  blockedURL,
 		})
 	}
-	return feeds, nil
+	return blocked, nil
 }
 
-func (b *memoryBackend) FollowURL(ctx context.Context, uid string, url string) (microsub.Feed, error) {
-	subFeed := microsub.Feed{Type: "feed", URL: url}
+// Block adds url to the blocked authors of the user and removes the items
+// of that author from the channels of the user.
+func (b *memoryBackend) Block(ctx context.Context, url string) error {
+	if url == "" {
+		return fmt.Errorf("missing url to block")
+	}
 
-	var channelID int
-	err := b.database.QueryRow(`SELECT "id" FROM "channels" WHERE "uid" = $1`, uid).Scan(&channelID)
+	userID, _ := userid.FromContext(ctx)
+
+	_, err := b.database.Exec(
+		`INSERT INTO "blocks" ("user_id", "url", "created_at") VALUES ($1, $2, DEFAULT) ON CONFLICT ("user_id", "url") DO NOTHING`,
+		userID,
+		url,
+	)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return microsub.Feed{}, fmt.Errorf("channel does not exist: %w", err)
-		}
-		return microsub.Feed{}, err
+		return err
 	}
 
-	var feedID int
-	err = b.database.QueryRow(
-		`INSERT INTO "feeds" ("channel_id", "url", "tier", "unmodified", "next_fetch_at") VALUES ($1, $2, 1, 0, now()) RETURNING "id"`,
-		channelID,
-		subFeed.URL,
-	).Scan(&feedID)
+	// Delete the items of the blocked user from all channels of this user.
+	// Timelines that can't delete items remember them as removed. Deleted and
+	// removed items are not added again, and new items of the user are
+	// skipped by isBlocked.
+	rows, err := b.database.Query(`SELECT "uid" FROM "channels" WHERE "user_id" = $1`, userID)
 	if err != nil {
-		return subFeed, err
+		return fmt.Errorf("while removing items of blocked user: %w", err)
+	}
+	var channels []string
+	for rows.Next() {
+		var channel string
+		if err := rows.Scan(&channel); err != nil {
+			rows.Close()
+			return fmt.Errorf("while removing items of blocked user: %w", err)
+		}
+		channels = append(channels, channel)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("while removing items of blocked user: %w", err)
 	}
 
-	var newFeed = feed{
-		ID:          feedID,
-		UID:         uid,
-		URL:         url,
-		Tier:        1,
-		Unmodified:  0,
-		NextFetchAt: time.Now(),
+	for _, channel := range channels {
+		timelineType, err := b.channelTimelineType(channel)
+		if err != nil {
+			return err
+		}
+		tl := timeline.Create(ctx, channel, timelineType, b.pool, b.database)
+		if tl == nil {
+			continue
+		}
+		uids, err := b.authorItemUIDs(ctx, channel, timelineType, tl, url)
+		if err != nil {
+			return fmt.Errorf("while removing items of blocked user from %s: %w", channel, err)
+		}
+		if len(uids) == 0 {
+			continue
+		}
+		if deleter, ok := tl.(timeline.Deleter); ok {
+			err = deleter.Delete(ctx, uids)
+		} else {
+			err = tl.Remove(ctx, uids)
+		}
+		if err != nil {
+			return fmt.Errorf("while removing items of blocked user from %s: %w", channel, err)
+		}
+		for _, itemID := range uids {
+			if err := removeFromSearch(channel, itemID); err != nil {
+				log.Println(err)
+			}
+		}
+		if err := b.updateChannelUnreadCount(ctx, channel); err != nil {
+			log.Printf("error while updating unread count for %s: %s", channel, err)
+		}
 	}
-	resp, err := b.Fetch3(ctx, uid, subFeed.URL)
-	if err != nil {
-		log.Println(err)
-		b.addNotification("Error while fetching feed", newFeed, err)
-		_ = b.updateChannelUnreadCount("notifications")
-		return subFeed, err
+
+	return nil
+}
+
+// authorItemUIDs returns the uids of the items in the timeline of channel by
+// the author with authorURL. Postgres timelines are searched in the database,
+// the other timelines are read completely.
+func (b *memoryBackend) authorItemUIDs(ctx context.Context, channel, timelineType string, tl timeline.Backend, authorURL string) ([]string, error) {
+	var uids []string
+
+	if timelineType == defaultTimelineType {
+		rows, err := b.database.QueryContext(ctx, `
+SELECT "i"."uid" FROM "items" "i"
+INNER JOIN "channels" "c" ON "c"."id" = "i"."channel_id"
+WHERE "c"."uid" = $1 AND "i"."data"->'author'->>'url' = $2
+`, channel, authorURL)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var uid string
+			if err := rows.Scan(&uid); err != nil {
+				return nil, err
+			}
+			uids = append(uids, uid)
+		}
+		return uids, rows.Err()
+	}
+
+	byAuthor := func(items []microsub.Item) {
+		for _, item := range items {
+			if item.Author != nil && item.Author.URL == authorURL {
+				uids = append(uids, item.ID)
+			}
+		}
 	}
-	defer resp.Body.Close()
 
-	_, _ = b.ProcessContent(uid, fmt.Sprintf("%d", feedID), subFeed.URL, resp.Header.Get("Content-Type"), resp.Body)
+	after := ""
+	for {
+		page, err := tl.Items(ctx, "", after, microsub.TimelineFilter{Limit: timeline.MaxLimit})
+		if err != nil {
+			return nil, err
+		}
+		byAuthor(page.Items)
+		if page.Paging.After == "" || len(page.Items) == 0 {
+			break
+		}
+		after = page.Paging.After
+	}
 
-	_, _ = b.hubBackend.CreateFeed(url)
+	// Some timelines don't return read items from Items
+	if lister, ok := tl.(timeline.ReadLister); ok {
+		read, err := lister.ReadItems(ctx)
+		if err != nil {
+			return nil, err
+		}
+		byAuthor(read)
+	}
 
-	return subFeed, nil
+	return uids, nil
 }
 
-func (b *memoryBackend) UnfollowURL(ctx context.Context, uid string, url string) error {
-	_, err := b.database.Exec(`DELETE FROM "feeds" "f" USING "channels" "c" WHERE "c"."id" = "f"."channel_id" AND f.url = $1 AND c.uid = $2`, url, uid)
+func (b *memoryBackend) Unblock(ctx context.Context, url string) error {
+	userID, _ := userid.FromContext(ctx)
+
+	_, err := b.database.Exec(`DELETE FROM "blocks" WHERE "user_id" = $1 AND "url" = $2`, userID, url)
 	return err
 }
 
+// isBlocked returns true when the author of the item is blocked by the owner of the channel
+func (b *memoryBackend) isBlocked(channel string, item microsub.Item) (bool, error) {
+	if item.Author == nil || item.Author.URL == "" {
+		return false, nil
+	}
+
+	var count int
+	err := b.database.QueryRow(
+		`SELECT COUNT(*) FROM "blocks" "b" INNER JOIN "channels" "c" ON "c"."user_id" = "b"."user_id" WHERE "c"."uid" = $1 AND "b"."url" = $2`,
+		channel,
+		item.Author.URL,
+	).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 func checkURL(u string) bool {
 	testURL, err := url.Parse(u)
 	if err != nil {
 		return false
 	}
 
-	resp, err := http.Head(testURL.String())
+	resp, err := fetchClient.Head(testURL.String())
 
 	if err != nil {
 		log.Printf("Error while HEAD %s: %v\n", u, err)
@@ -554,11 +1482,25 @@ func (b *memoryBackend) ItemSearch(ctx context.Context, channel, query string) (
 		return nil, fmt.Errorf("querySearch failed: %w", err)
 	}
 
-	tl, err := b.getTimeline(channel)
+	tl, err := b.getUserTimeline(ctx, channel)
 	if err != nil {
 		return nil, fmt.Errorf("querySearch failed: %w", err)
 	}
-	return tl.ItemsByUID(ids)
+
+	// The search index can contain items that are removed, or that are in
+	// the global timeline of other users, these are skipped
+	items := []microsub.Item{}
+	for _, id := range ids {
+		found, err := tl.ItemsByUID(ctx, []string{id})
+		if errors.Is(err, timeline.ErrItemNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, found...)
+	}
+	return items, nil
 }
 
 func (b *memoryBackend) Search(ctx context.Context, query string) ([]microsub.Feed, error) {
@@ -567,11 +1509,11 @@ func (b *memoryBackend) Search(ctx context.Context, query string) ([]microsub.Fe
 	// needs to be like this, because we get a null result otherwise in the json output
 	feeds := []microsub.Feed{}
 
-	cachingFetch := WithCaching(b.pool, fetch.FetcherFunc(Fetch2))
+	fetcher := b.getFetcher()
 
 	for _, u := range urls {
 		log.Println(u)
-		resp, err := cachingFetch.Fetch(u)
+		resp, err := fetcher.FetchWithContext(ctx, u)
 		if err != nil {
 			log.Printf("Error while fetching %s: %v\n", u, err)
 			continue
@@ -585,7 +1527,7 @@ func (b *memoryBackend) Search(ctx context.Context, query string) ([]microsub.Fe
 			continue
 		}
 
-		feedResp, err := cachingFetch.Fetch(fetchURL.String())
+		feedResp, err := fetcher.FetchWithContext(ctx, fetchURL.String())
 		if err != nil {
 			log.Printf("Error in fetch of %s - %v\n", fetchURL, err)
 			continue
@@ -593,7 +1535,7 @@ func (b *memoryBackend) Search(ctx context.Context, query string) ([]microsub.Fe
 		defer feedResp.Body.Close()
 
 		// TODO: Combine FeedHeader and FeedItems so we can use it here
-		parsedFeed, err := fetch.FeedHeader(cachingFetch, fetchURL.String(), feedResp.Header.Get("Content-Type"), feedResp.Body)
+		parsedFeed, err := fetch.FeedHeader(fetcher, fetchURL.String(), feedResp.Header.Get("Content-Type"), feedResp.Body)
 		if err != nil {
 			log.Printf("Error in parse of %s - %v\n", fetchURL, err)
 			continue
@@ -608,7 +1550,7 @@ func (b *memoryBackend) Search(ctx context.Context, query string) ([]microsub.Fe
 				log.Printf("alternate found with type %s %#v\n", relURL.Type, relURL)
 
 				if strings.HasPrefix(relURL.Type, "text/html") || strings.HasPrefix(relURL.Type, "application/json") || strings.HasPrefix(relURL.Type, "application/xml") || strings.HasPrefix(relURL.Type, "text/xml") || strings.HasPrefix(relURL.Type, "application/rss+xml") || strings.HasPrefix(relURL.Type, "application/atom+xml") {
-					feedResp, err := cachingFetch.Fetch(alt)
+					feedResp, err := fetcher.FetchWithContext(ctx, alt)
 					if err != nil {
 						log.Printf("Error in fetch of %s - %v\n", alt, err)
 						continue
@@ -616,7 +1558,7 @@ func (b *memoryBackend) Search(ctx context.Context, query string) ([]microsub.Fe
 					// FIXME: don't defer in for loop (possible memory leak)
 					defer feedResp.Body.Close()
 
-					parsedFeed, err := fetch.FeedHeader(cachingFetch, alt, feedResp.Header.Get("Content-Type"), feedResp.Body)
+					parsedFeed, err := fetch.FeedHeader(fetcher, alt, feedResp.Header.Get("Content-Type"), feedResp.Body)
 					if err != nil {
 						log.Printf("Error in parse of %s - %v\n", alt, err)
 						continue
@@ -632,14 +1574,14 @@ func (b *memoryBackend) Search(ctx context.Context, query string) ([]microsub.Fe
 }
 
 func (b *memoryBackend) PreviewURL(ctx context.Context, previewURL string) (microsub.Timeline, error) {
-	cachingFetch := WithCaching(b.pool, fetch.FetcherFunc(Fetch2))
-	resp, err := cachingFetch.Fetch(previewURL)
+	fetcher := b.getFetcher()
+	resp, err := fetcher.FetchWithContext(ctx, previewURL)
 	if err != nil {
 		return microsub.Timeline{}, fmt.Errorf("error while fetching %s: %v", previewURL, err)
 	}
 	defer resp.Body.Close()
 
-	items, err := ProcessSourcedItems(cachingFetch, previewURL, resp.Header.Get("content-type"), resp.Body)
+	items, err := ProcessSourcedItems(fetcher, previewURL, resp.Header.Get("content-type"), resp.Body)
 	if err != nil {
 		return microsub.Timeline{}, fmt.Errorf("error while fetching %s: %v", previewURL, err)
 	}
@@ -650,16 +1592,115 @@ func (b *memoryBackend) PreviewURL(ctx context.Context, previewURL string) (micr
 }
 
 func (b *memoryBackend) MarkRead(ctx context.Context, channel string, uids []string) error {
-	tl, err := b.getTimeline(channel)
+	tl, err := b.getUserTimeline(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	if err = tl.MarkRead(ctx, uids); err != nil {
+		return err
+	}
+
+	if err = b.updateChannelUnreadCount(ctx, channel); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (b *memoryBackend) MarkReadUntil(ctx context.Context, channel, lastReadEntry string) error {
+	tl, err := b.getUserTimeline(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	err = tl.MarkReadUntil(ctx, lastReadEntry)
+	if errors.Is(err, timeline.ErrItemNotFound) {
+		return fmt.Errorf("entry %q: %w", lastReadEntry, microsub.ErrEntryNotFound)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = b.updateChannelUnreadCount(ctx, channel); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (b *memoryBackend) MarkUnread(ctx context.Context, channel string, uids []string) error {
+	tl, err := b.getUserTimeline(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	if err = tl.MarkUnread(ctx, uids); err != nil {
+		return err
+	}
+
+	if err = b.updateChannelUnreadCount(ctx, channel); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (b *memoryBackend) RemoveItems(ctx context.Context, channel string, uids []string) error {
+	tl, err := b.getUserTimeline(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	if err = tl.Remove(ctx, uids); err != nil {
+		return err
+	}
+
+	for _, uid := range uids {
+		if err = removeFromSearch(channel, uid); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if err = b.updateChannelUnreadCount(ctx, channel); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// StarItems stars the items, so they are shown in the "saved" channel and are
+// kept by channels that keep starred items
+func (b *memoryBackend) StarItems(ctx context.Context, channel string, uids []string) error {
+	return b.setStarred(ctx, channel, uids, true)
+}
+
+// UnstarItems removes the star from the items
+func (b *memoryBackend) UnstarItems(ctx context.Context, channel string, uids []string) error {
+	return b.setStarred(ctx, channel, uids, false)
+}
+
+func (b *memoryBackend) setStarred(ctx context.Context, channel string, uids []string, starred bool) error {
+	tl, err := b.getUserTimeline(ctx, channel)
 	if err != nil {
 		return err
 	}
 
-	if err = tl.MarkRead(uids); err != nil {
+	starrer, ok := tl.(timeline.Starrer)
+	if !ok {
+		return fmt.Errorf("timeline id %q: %w", channel, ErrStarNotSupported)
+	}
+
+	if starred {
+		err = starrer.Star(ctx, uids)
+	} else {
+		err = starrer.Unstar(ctx, uids)
+	}
+	if err != nil {
 		return err
 	}
 
-	if err = b.updateChannelUnreadCount(channel); err != nil {
+	if err = b.updateChannelUnreadCount(ctx, timeline.SavedChannel); err != nil {
 		return err
 	}
 
@@ -672,11 +1713,18 @@ func (b *memoryBackend) Events(ctx context.Context) (chan sse.Message, error) {
 
 // ProcessSourcedItems processes items and adds the Source
 func ProcessSourcedItems(fetcher fetch.Fetcher, fetchURL, contentType string, body io.Reader) ([]microsub.Item, error) {
+	items, _, err := processSourcedItems(fetcher, fetchURL, contentType, body)
+	return items, err
+}
+
+// processSourcedItems processes items and adds the Source, and returns the
+// update interval that the feed advertises
+func processSourcedItems(fetcher fetch.Fetcher, fetchURL, contentType string, body io.Reader) ([]microsub.Item, time.Duration, error) {
 	// When the source is available from the Header, we fill the Source of the item
 
 	bodyBytes, err := ioutil.ReadAll(body)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	var source *microsub.Source
@@ -694,9 +1742,9 @@ func ProcessSourcedItems(fetcher fetch.Fetcher, fetchURL, contentType string, bo
 		}
 	}
 
-	items, err := fetch.FeedItems(fetcher, fetchURL, contentType, bytes.NewBuffer(bodyBytes))
+	items, interval, err := fetch.FeedItemsWithInterval(fetcher, fetchURL, contentType, bytes.NewBuffer(bodyBytes))
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	for i, item := range items {
@@ -705,35 +1753,47 @@ func ProcessSourcedItems(fetcher fetch.Fetcher, fetchURL, contentType string, bo
 		items[i] = item
 	}
 
-	return items, nil
+	return items, interval, nil
 }
 
 // ContentProcessor processes content for a channel and feed
 type ContentProcessor interface {
-	ProcessContent(channel, feedID, fetchURL, contentType string, body io.Reader) (bool, error)
+	ProcessContent(ctx context.Context, channel, feedID, fetchURL, contentType string, body io.Reader) (bool, error)
 }
 
 // ProcessContent processes content of a feed, returns if the feed has changed or not
-func (b *memoryBackend) ProcessContent(channel, feedID, fetchURL, contentType string, body io.Reader) (bool, error) {
-	cachingFetch := WithCaching(b.pool, fetch.FetcherFunc(Fetch2))
-
-	items, err := ProcessSourcedItems(cachingFetch, fetchURL, contentType, body)
+func (b *memoryBackend) ProcessContent(ctx context.Context, channel, feedID, fetchURL, contentType string, body io.Reader) (bool, error) {
+	items, err := ProcessSourcedItems(b.getFetcher(), fetchURL, contentType, body)
 	if err != nil {
 		return false, err
 	}
 
+	return b.addFeedItems(ctx, channel, feedID, items)
+}
+
+// addFeedItems adds the items of the feed to channel, returns if the channel
+// has changed or not
+func (b *memoryBackend) addFeedItems(ctx context.Context, channel, feedID string, items []microsub.Item) (bool, error) {
 	changed := false
 
 	for _, item := range items {
+		blocked, err := b.isBlocked(channel, item)
+		if err != nil {
+			log.Printf("ERROR: (feedID=%s) %s\n", feedID, err)
+		}
+		if blocked {
+			continue
+		}
+
 		item.Source.ID = feedID
-		added, err := b.channelAddItemWithMatcher(channel, item)
+		added, err := b.channelAddItemWithMatcher(ctx, channel, item)
 		if err != nil {
 			log.Printf("ERROR: (feedID=%s) %s\n", feedID, err)
 		}
 		changed = changed || added
 	}
 
-	err = b.updateChannelUnreadCount(channel)
+	err := b.updateChannelUnreadCount(ctx, channel)
 	if err != nil {
 		return changed, err
 	}
@@ -747,7 +1807,7 @@ func (b *memoryBackend) Fetch3(ctx context.Context, channel, fetchURL string) (*
 	return Fetch2(ctx, fetchURL)
 }
 
-func (b *memoryBackend) channelAddItemWithMatcher(channel string, item microsub.Item) (bool, error) {
+func (b *memoryBackend) channelAddItemWithMatcher(ctx context.Context, channel string, item microsub.Item) (bool, error) {
 	// an item is posted
 	// check for all channels as channel
 	// if regex matches item
@@ -785,13 +1845,22 @@ func (b *memoryBackend) channelAddItemWithMatcher(channel string, item microsub.
 
 	// Update all channels that have added items, because the include_regex matches
 	for _, value := range updatedChannels {
-		err := b.updateChannelUnreadCount(value)
+		err := b.updateChannelUnreadCount(ctx, value)
 		if err != nil {
 			log.Printf("error while updating unread count for %s: %s", value, err)
 			continue
 		}
 	}
 
+	muted, err := b.isMuted(channel, item)
+	if err != nil {
+		log.Printf("error while checking muted authors for %s: %s", channel, err)
+	}
+	if muted {
+		log.Printf("Muted %s in %s\n", item.Author.URL, channel)
+		return false, nil
+	}
+
 	// Check for the exclude regex
 	setting, _ := b.loadSetting(channel)
 
@@ -834,7 +1903,7 @@ func (b *memoryBackend) channelAddItemWithMatcher(channel string, item microsub.
 		}
 	}
 
-	added, err := b.channelAddItem(channel, item)
+	added, err := b.channelAddItem(ctx, channel, item)
 
 	if err != nil {
 		return added, err
@@ -874,13 +1943,13 @@ func matchItemText(item microsub.Item, re *regexp.Regexp) bool {
 	return re.MatchString(item.Name)
 }
 
-func (b *memoryBackend) channelAddItem(channel string, item microsub.Item) (bool, error) {
-	timelineBackend, err := b.getTimeline(channel)
+func (b *memoryBackend) channelAddItem(ctx context.Context, channel string, item microsub.Item) (bool, error) {
+	timelineBackend, err := b.getTimeline(ctx, channel)
 	if err != nil {
 		return false, err
 	}
 
-	added, err := timelineBackend.AddItem(item)
+	added, err := timelineBackend.AddItem(ctx, item)
 	if err != nil {
 		return added, err
 	}
@@ -899,13 +1968,16 @@ var ErrNotUpdated = errors.New("timeline unread count not updated")
 // ErrNotFound is used when the timeline is not found
 var ErrNotFound = errors.New("timeline not found")
 
-func (b *memoryBackend) updateChannelUnreadCount(channel string) error {
-	tl, err := b.getTimeline(channel)
+// ErrStarNotSupported is used when the timeline type can't star items
+var ErrStarNotSupported = errors.New("timeline type does not support starred items")
+
+func (b *memoryBackend) updateChannelUnreadCount(ctx context.Context, channel string) error {
+	tl, err := b.getUserTimeline(ctx, channel)
 	if err != nil {
 		return err
 	}
 
-	unread, err := tl.Count()
+	unread, err := tl.Count(ctx)
 	if err != nil {
 		return ErrNotUpdated
 	}
@@ -921,61 +1993,14 @@ func (b *memoryBackend) updateChannelUnreadCount(channel string) error {
 	return nil
 }
 
-// WithCaching adds caching to a fetch.Fetcher
-func WithCaching(pool *redis.Pool, ff fetch.Fetcher) fetch.Fetcher {
-	ff2 := (func(ctx context.Context, fetchURL string) (*http.Response, error) {
-		conn := pool.Get()
-		defer conn.Close()
-
-		cacheKey := fmt.Sprintf("http_cache:%s", fetchURL)
-		u, err := url.Parse(fetchURL)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing %s as url: %s", fetchURL, err)
-		}
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
-		if err != nil {
-			return nil, err
-		}
-
-		data, err := redis.Bytes(conn.Do("GET", cacheKey))
-		if err == nil {
-			log.Printf("HIT %s\n", fetchURL)
-			rd := bufio.NewReader(bytes.NewReader(data))
-			return http.ReadResponse(rd, req)
-		}
-
-		log.Printf("MISS %s\n", fetchURL)
-
-		resp, err := ff.FetchWithContext(ctx, fetchURL)
-		if err != nil {
-			return nil, err
-		}
-		defer resp.Body.Close()
-
-		var b bytes.Buffer
-		err = resp.Write(&b)
-		if err != nil {
-			return nil, err
-		}
-
-		cachedCopy := make([]byte, b.Len())
-		cur := b.Bytes()
-		copy(cachedCopy, cur)
-
-		conn.Do("SET", cacheKey, cachedCopy, "EX", 60*60)
-
-		cachedResp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(cachedCopy)), req)
-		return cachedResp, err
-	})
-	return fetch.FetcherFunc(ff2)
-}
-
-// ErrBlackList returned when Url is not something we should fetch
-var ErrBlackList = errors.New("Blacklisted URL")
-
 // Fetch2 fetches stuff
 func Fetch2(ctx context.Context, fetchURL string) (*http.Response, error) {
+	return fetchIfModified(ctx, fetchURL, "", "")
+}
+
+// fetchIfModified fetches fetchURL. When etag or lastModified are set, the
+// request is conditional and the response can be 304 Not Modified.
+func fetchIfModified(ctx context.Context, fetchURL, etag, lastModified string) (*http.Response, error) {
 	if !strings.HasPrefix(fetchURL, "http") {
 		return nil, fmt.Errorf("error parsing %s as url, has no http(s) prefix", fetchURL)
 	}
@@ -985,24 +2010,25 @@ func Fetch2(ctx context.Context, fetchURL string) (*http.Response, error) {
 		return nil, fmt.Errorf("error parsing %s as url: %s", fetchURL, err)
 	}
 
-	if strings.Contains(u.Host, "twitter.com") {
-		if !strings.Contains(u.Path, "/status/") {
-			return nil, ErrBlackList
-		}
-	}
-	if strings.Contains(u.Host, "reddit.com") {
-		return nil, ErrBlackList
-	}
-
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	return doRequest(req)
+}
 
-	client := http.Client{}
-	resp, err := client.Do(req)
+// doRequest sends req with fetchClient, which applies the fetch policy
+func doRequest(req *http.Request) (*http.Response, error) {
+	resp, err := fetchClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("fetch failed: %s", err)
+		return nil, fmt.Errorf("fetch failed: %w", err)
 	}
 
 	return resp, err
@@ -1017,13 +2043,13 @@ func (b *memoryBackend) loadSetting(uid string) (channelSetting, error) {
 	}
 
 	var settingsID int
-	var setting channelSetting
+	setting := newChannelSetting()
 
 	row = b.database.QueryRow(`SELECT "id", "settings" FROM "channel_settings" WHERE "channel_id" = $1`, channelID)
 
 	err = row.Scan(&settingsID, &setting)
 	if err == sql.ErrNoRows {
-		return channelSetting{}, nil
+		return newChannelSetting(), nil
 	}
 	if err != nil {
 		return channelSetting{}, err
@@ -1051,10 +2077,63 @@ ON CONFLICT (channel_id) DO UPDATE
 	return err
 }
 
-func (b *memoryBackend) getTimeline(channel string) (timeline.Backend, error) {
+// channelTimelineType returns the timeline type of channel
+func (b *memoryBackend) channelTimelineType(channel string) (string, error) {
+	timelineType := b.timelineType
+	if timelineType == "" {
+		setting, err := b.loadSetting(channel)
+		if err != nil && err != sql.ErrNoRows {
+			return "", fmt.Errorf("timeline id %q: %w", channel, err)
+		}
+		timelineType = setting.ChannelType
+	}
 	// Set a default timeline type if not set
-	timelineType := "postgres-stream"
-	tl := timeline.Create(channel, timelineType, b.pool, b.database)
+	if timelineType == "" {
+		timelineType = defaultTimelineType
+	}
+	return timelineType, nil
+}
+
+func (b *memoryBackend) getTimeline(ctx context.Context, channel string) (timeline.Backend, error) {
+	timelineType, err := b.channelTimelineType(channel)
+	if err != nil {
+		return nil, err
+	}
+	tl := timeline.Create(ctx, channel, timelineType, b.pool, b.database)
+	if tl == nil {
+		return tl, fmt.Errorf("timeline id %q: %w", channel, ErrNotFound)
+	}
+	return tl, nil
+}
+
+// channelTimelineTypes are the timeline types that a channel can be moved to.
+// The memory timeline is not persistent and only used in tests.
+var channelTimelineTypes = []string{"postgres-stream", "sorted-set", "stream"}
+
+// isTimelineType returns true when a channel can use timelineType
+func isTimelineType(timelineType string) bool {
+	for _, t := range channelTimelineTypes {
+		if t == timelineType {
+			return true
+		}
+	}
+	return false
+}
+
+// getUserTimeline returns the timeline for channel, the timeline with the
+// items of all channels of the user for the "global" channel, or the timeline
+// with the starred items of all channels for the "saved" channel.
+func (b *memoryBackend) getUserTimeline(ctx context.Context, channel string) (timeline.Backend, error) {
+	var tl timeline.Backend
+	userID, _ := userid.FromContext(ctx)
+	switch channel {
+	case timeline.GlobalChannel:
+		tl = timeline.CreateGlobal(ctx, userID, b.database)
+	case timeline.SavedChannel:
+		tl = timeline.CreateSaved(ctx, userID, b.database)
+	default:
+		return b.getTimeline(ctx, channel)
+	}
 	if tl == nil {
 		return tl, fmt.Errorf("timeline id %q: %w", channel, ErrNotFound)
 	}
@@ -18,6 +18,191 @@
 
 package main
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pstuifzand/ekster/pkg/fetch"
+	"github.com/pstuifzand/ekster/pkg/microsub"
+	"github.com/pstuifzand/ekster/pkg/sse"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMemoryBackend() *memoryBackend {
+	return &memoryBackend{broker: sse.NewBroker(), timelineType: "memory"}
+}
+
+func Test_memoryBackend_channelAddItem(t *testing.T) {
+	ctx := context.Background()
+	b := newTestMemoryBackend()
+
+	item := microsub.Item{Type: "entry", ID: "item-1", Published: "2022-01-01T12:00:00Z"}
+
+	added, err := b.channelAddItem(ctx, "test-add-item", item)
+	assert.NoError(t, err)
+	assert.True(t, added)
+
+	added, err = b.channelAddItem(ctx, "test-add-item", item)
+	assert.NoError(t, err)
+	assert.False(t, added)
+
+	assert.NoError(t, b.updateChannelUnreadCount(ctx, "test-add-item"))
+}
+
+func Test_memoryBackend_ReadState(t *testing.T) {
+	ctx := context.Background()
+	b := newTestMemoryBackend()
+	channel := "test-read-state"
+
+	for _, item := range []microsub.Item{
+		{Type: "entry", ID: "item-1", Published: "2022-01-01T12:00:00Z"},
+		{Type: "entry", ID: "item-2", Published: "2022-01-01T12:01:00Z"},
+		{Type: "entry", ID: "item-3", Published: "2022-01-01T12:02:00Z"},
+	} {
+		_, err := b.channelAddItem(ctx, channel, item)
+		assert.NoError(t, err)
+	}
+
+	tl, err := b.getTimeline(ctx, channel)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, b.MarkReadUntil(ctx, channel, "item-2"))
+	count, _ := tl.Count(ctx)
+	assert.Equal(t, 1, count)
+
+	assert.ErrorIs(t, b.MarkReadUntil(ctx, channel, "missing"), microsub.ErrEntryNotFound)
+
+	assert.NoError(t, b.MarkUnread(ctx, channel, []string{"item-1"}))
+	count, _ = tl.Count(ctx)
+	assert.Equal(t, 2, count)
+
+	assert.NoError(t, b.RemoveItems(ctx, channel, []string{"item-3"}))
+	count, _ = tl.Count(ctx)
+	assert.Equal(t, 1, count)
+
+	assert.NoError(t, b.MarkRead(ctx, channel, []string{"item-1"}))
+	count, _ = tl.Count(ctx)
+	assert.Equal(t, 0, count)
+
+	page, err := tl.Items(ctx, "", "", microsub.TimelineFilter{})
+	assert.NoError(t, err)
+	if assert.Len(t, page.Items, 2) {
+		assert.Equal(t, "item-2", page.Items[0].ID)
+		assert.Equal(t, "item-1", page.Items[1].ID)
+	}
+}
+
+func Test_memoryBackend_pruneChannel(t *testing.T) {
+	ctx := context.Background()
+	b := newTestMemoryBackend()
+	channel := "test-prune"
+
+	for _, item := range []microsub.Item{
+		{Type: "entry", ID: "item-1", Published: "2022-01-01T12:00:00Z"},
+		{Type: "entry", ID: "item-2", Published: "2022-01-01T12:01:00Z"},
+		{Type: "entry", ID: "item-3", Published: time.Now().Format(time.RFC3339)},
+	} {
+		_, err := b.channelAddItem(ctx, channel, item)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, b.MarkRead(ctx, channel, []string{"item-1"}))
+
+	n, err := b.pruneChannel(ctx, channel, channelSetting{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = b.pruneChannel(ctx, channel, channelSetting{MaxAge: 30, KeepUnread: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = b.pruneChannel(ctx, channel, channelSetting{MaxItems: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	tl, _ := b.getTimeline(ctx, channel)
+	page, err := tl.Items(ctx, "", "", microsub.TimelineFilter{})
+	assert.NoError(t, err)
+	if assert.Len(t, page.Items, 1) {
+		assert.Equal(t, "item-3", page.Items[0].ID)
+	}
+}
+
+func Test_isTimelineType(t *testing.T) {
+	assert.True(t, isTimelineType("postgres-stream"))
+	assert.True(t, isTimelineType("sorted-set"))
+	assert.True(t, isTimelineType("stream"))
+	assert.False(t, isTimelineType("memory"))
+	assert.False(t, isTimelineType("null"))
+	assert.False(t, isTimelineType(""))
+}
+
+// allowLoopback lets fetchClient connect to httptest servers until the end of
+// the test
+func allowLoopback(t *testing.T) {
+	policy := fetch.DefaultPolicy()
+	policy.AllowAddresses = []string{"127.0.0.0/8", "::1"}
+	saved := fetchClient
+	fetchClient = fetch.NewClient(policy)
+	t.Cleanup(func() { fetchClient = saved })
+}
+
+func Test_fetchIfModified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "feed")
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+
+	_, err := fetchIfModified(ctx, server.URL, "", "")
+	assert.True(t, errors.Is(err, fetch.ErrBlockedAddress))
+
+	allowLoopback(t)
+
+	resp, err := fetchIfModified(ctx, server.URL, "", "")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, `"v1"`, resp.Header.Get("ETag"))
+	}
+
+	resp, err = fetchIfModified(ctx, server.URL, `"v1"`, "")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	}
+}
+
+func Test_feed_updateTier(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	f := feed{Tier: 1}
+
+	f.updateTier(false, now)
+	assert.Equal(t, 1, f.Tier)
+	assert.Equal(t, 1, f.Unmodified)
+
+	f.updateTier(false, now)
+	assert.Equal(t, 2, f.Tier)
+	assert.Equal(t, 0, f.Unmodified)
+	assert.Equal(t, now.Add(4*time.Minute), f.NextFetchAt)
+
+	f.updateTier(true, now)
+	assert.Equal(t, 1, f.Tier)
+	assert.Equal(t, now.Add(2*time.Minute), f.NextFetchAt)
+}
+
 // func Test_memoryBackend_ChannelsCreate(t *testing.T) {
 // 	type fields struct {
 // 		hubIncomingBackend hubIncomingBackend
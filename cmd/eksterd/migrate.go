@@ -0,0 +1,76 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/pstuifzand/ekster/pkg/timeline"
+)
+
+// migrateTimeline copies the items of channel to a timeline of timelineType
+// and makes it the timeline type of the channel. The items of the old
+// timeline are deleted, so migrating back later doesn't find stale items.
+func migrateTimeline(ctx context.Context, b *memoryBackend, channel, timelineType string) error {
+	if !isTimelineType(timelineType) {
+		return fmt.Errorf("unknown timeline type %q, use one of %v", timelineType, channelTimelineTypes)
+	}
+
+	setting, err := b.loadSetting(channel)
+	if err != nil {
+		return fmt.Errorf("channel %q: %w", channel, err)
+	}
+
+	currentType := setting.ChannelType
+	if currentType == "" {
+		currentType = defaultTimelineType
+	}
+	if currentType == timelineType {
+		return fmt.Errorf("channel %q already uses timeline type %q", channel, timelineType)
+	}
+
+	src := timeline.Create(ctx, channel, currentType, b.pool, b.database)
+	if src == nil {
+		return fmt.Errorf("could not create %s timeline for %q", currentType, channel)
+	}
+	dst := timeline.Create(ctx, channel, timelineType, b.pool, b.database)
+	if dst == nil {
+		return fmt.Errorf("could not create %s timeline for %q", timelineType, channel)
+	}
+
+	n, err := timeline.Copy(ctx, dst, src)
+	if err != nil {
+		return fmt.Errorf("while copying items of %q: %w", channel, err)
+	}
+	log.Printf("Copied %d items of %q from %s to %s", n, channel, currentType, timelineType)
+
+	setting.ChannelType = timelineType
+	if err := b.saveSetting(channel, setting); err != nil {
+		return err
+	}
+
+	if clearer, ok := src.(timeline.Clearer); ok {
+		if err := clearer.Clear(ctx); err != nil {
+			return fmt.Errorf("while clearing the %s timeline of %q: %w", currentType, channel, err)
+		}
+	}
+	return nil
+}
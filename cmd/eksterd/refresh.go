@@ -0,0 +1,308 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultFetchWorkers is the number of feeds that are fetched at the same
+	// time, when not configured
+	defaultFetchWorkers = 4
+
+	// defaultFetchHostInterval is the minimum time between requests to the
+	// same host, when not configured
+	defaultFetchHostInterval = 1 * time.Second
+
+	// maxFetchHint is the longest time until the next fetch that a server or
+	// feed can ask for
+	maxFetchHint = 24 * time.Hour
+
+	// minFailureBackoff is the time until the next fetch after a failed
+	// fetch. It doubles with every failure in a row, up to maxFetchHint.
+	minFailureBackoff = 5 * time.Minute
+
+	// unhealthyFailures is the number of failed fetches in a row after which
+	// a feed is reported in the notifications channel
+	unhealthyFailures = 3
+)
+
+// statusError is returned when the server answers with an error status.
+// RetryAfter is the Retry-After of a 429 or 503 response.
+type statusError struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (err *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %s", err.Status)
+}
+
+// failureBackoff returns the time until the next fetch of a feed that failed
+// failures times in a row
+func failureBackoff(failures int) time.Duration {
+	backoff := minFailureBackoff
+	for i := 1; i < failures && backoff < maxFetchHint; i++ {
+		backoff *= 2
+	}
+	if backoff > maxFetchHint {
+		backoff = maxFetchHint
+	}
+	return backoff
+}
+
+// failed records a failed fetch of the feed and delays the next fetch. The
+// next fetch is at the later of the backoff and the Retry-After of the server.
+func (feed *feed) failed(err error, now time.Time) {
+	feed.Failures++
+	feed.LastError = err.Error()
+	feed.LastStatus = 0
+	feed.NextFetchAt = now.Add(failureBackoff(feed.Failures))
+	var se *statusError
+	if errors.As(err, &se) {
+		feed.LastStatus = se.StatusCode
+		feed.applyFetchHint(se.RetryAfter, now)
+	}
+}
+
+// permanentRedirect returns the url that resp was permanently redirected to.
+// Only the 301 and 308 redirects at the start of the redirect chain count, a
+// temporary redirect after them does not move the feed. The feed only moves
+// when the final response is successful (2xx or 304).
+func permanentRedirect(resp *http.Response) (string, bool) {
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		return "", false
+	}
+
+	// chain contains the requests from the first to the last
+	var chain []*http.Request
+	req := resp.Request
+	for req != nil {
+		chain = append([]*http.Request{req}, chain...)
+		if req.Response == nil {
+			break
+		}
+		req = req.Response.Request
+	}
+
+	movedTo := ""
+	for i := 1; i < len(chain); i++ {
+		status := chain[i].Response.StatusCode
+		if status != http.StatusMovedPermanently && status != http.StatusPermanentRedirect {
+			break
+		}
+		movedTo = chain[i].URL.String()
+	}
+	return movedTo, movedTo != ""
+}
+
+// hostLimiter spaces the requests to the same host by at least interval
+type hostLimiter struct {
+	interval time.Duration
+
+	lock sync.Mutex
+	next map[string]time.Time
+}
+
+func newHostLimiter(interval time.Duration) *hostLimiter {
+	return &hostLimiter{
+		interval: interval,
+		next:     make(map[string]time.Time),
+	}
+}
+
+// readyAt returns the time from which a request to host is allowed. A nil
+// hostLimiter allows all requests.
+func (l *hostLimiter) readyAt(host string) time.Time {
+	if l == nil || l.interval <= 0 {
+		return time.Time{}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.next[host]
+}
+
+// reserve records a request to host at now
+func (l *hostLimiter) reserve(host string, now time.Time) {
+	if l == nil || l.interval <= 0 {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for h, next := range l.next {
+		if next.Before(now) {
+			delete(l.next, h)
+		}
+	}
+	l.next[host] = now.Add(l.interval)
+}
+
+// hostFeeds are the feeds of one host that still have to be fetched
+type hostFeeds struct {
+	host  string
+	feeds []feed
+}
+
+// dispatch sends the feeds to jobs, but a feed only when its host is ready.
+// The feeds of a host that is not ready wait in a queue per host, while the
+// feeds of other hosts are sent. dispatch only sleeps when no host is ready.
+func (l *hostLimiter) dispatch(ctx context.Context, feeds []feed, jobs chan<- feed) error {
+	var queues []*hostFeeds
+	byHost := make(map[string]*hostFeeds)
+	for _, feed := range feeds {
+		host := feed.URL
+		if u, err := url.Parse(feed.URL); err == nil {
+			host = u.Host
+		}
+		q, e := byHost[host]
+		if !e {
+			q = &hostFeeds{host: host}
+			byHost[host] = q
+			queues = append(queues, q)
+		}
+		q.feeds = append(q.feeds, feed)
+	}
+
+	for len(queues) > 0 {
+		sent := false
+		var next time.Time
+		waiting := queues[:0]
+		for _, q := range queues {
+			if at := l.readyAt(q.host); at.After(time.Now()) {
+				if next.IsZero() || at.Before(next) {
+					next = at
+				}
+				waiting = append(waiting, q)
+				continue
+			}
+
+			select {
+			case jobs <- q.feeds[0]:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			// the reservation starts when a worker takes the feed
+			l.reserve(q.host, time.Now())
+			sent = true
+
+			q.feeds = q.feeds[1:]
+			if len(q.feeds) > 0 {
+				waiting = append(waiting, q)
+			}
+		}
+		queues = waiting
+
+		if sent || len(queues) == 0 {
+			continue
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// responseFetchHint returns the time until the next fetch that the response
+// asks for. This is the Retry-After of 429 and 503 responses, or the max-age of
+// Cache-Control for other responses.
+func responseFetchHint(resp *http.Response, now time.Time) time.Duration {
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		return retryAfter(resp.Header.Get("Retry-After"), now)
+	}
+	return maxAge(resp.Header.Get("Cache-Control"))
+}
+
+// retryAfter parses the value of a Retry-After header, which is a number of
+// seconds or a date
+func retryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// maxAge returns the max-age of a Cache-Control header, or 0 when the response
+// should not be cached
+func maxAge(cacheControl string) time.Duration {
+	var age time.Duration
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if directive == "no-cache" || directive == "no-store" {
+			return 0
+		}
+		if strings.HasPrefix(directive, "max-age=") {
+			seconds, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(directive, "max-age="), `"`))
+			if err == nil && seconds > 0 {
+				age = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return age
+}
+
+// fetchHint returns the longer of the hint of the response and the interval
+// that the feed advertises with ttl or sy:updatePeriod, up to maxFetchHint
+func fetchHint(hint, interval time.Duration) time.Duration {
+	if interval > hint {
+		hint = interval
+	}
+	if hint > maxFetchHint {
+		hint = maxFetchHint
+	}
+	return hint
+}
+
+// applyFetchHint moves the next fetch of the feed to now+hint, when that is
+// later than the time chosen by updateTier. The hint is limited to
+// maxFetchHint.
+func (feed *feed) applyFetchHint(hint time.Duration, now time.Time) {
+	if hint > maxFetchHint {
+		hint = maxFetchHint
+	}
+	if next := now.Add(hint); next.After(feed.NextFetchAt) {
+		feed.NextFetchAt = next
+	}
+}
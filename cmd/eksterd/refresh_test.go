@@ -0,0 +1,270 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_hostLimiter_dispatch(t *testing.T) {
+	ctx := context.Background()
+	l := newHostLimiter(100 * time.Millisecond)
+
+	feeds := []feed{
+		{URL: "https://example.com/feed1"},
+		{URL: "https://example.com/feed2"},
+		{URL: "https://example.org/feed"},
+	}
+
+	type job struct {
+		url string
+		at  time.Duration
+	}
+	jobs := make(chan feed)
+	done := make(chan []job)
+	start := time.Now()
+	go func() {
+		var received []job
+		for feed := range jobs {
+			received = append(received, job{feed.URL, time.Since(start)})
+		}
+		done <- received
+	}()
+
+	assert.NoError(t, l.dispatch(ctx, feeds, jobs))
+	close(jobs)
+	received := <-done
+
+	// the feed of the other host is not held up by the second feed of
+	// example.com
+	if assert.Len(t, received, 3) {
+		assert.Equal(t, "https://example.com/feed1", received[0].url)
+		assert.Equal(t, "https://example.org/feed", received[1].url)
+		assert.Less(t, int64(received[1].at), int64(100*time.Millisecond))
+		assert.Equal(t, "https://example.com/feed2", received[2].url)
+		assert.GreaterOrEqual(t, int64(received[2].at-received[0].at), int64(100*time.Millisecond))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Error(t, l.dispatch(ctx, feeds, make(chan feed)))
+
+	var nilLimiter *hostLimiter
+	jobs = make(chan feed, len(feeds))
+	assert.NoError(t, nilLimiter.dispatch(context.Background(), feeds, jobs))
+	assert.Len(t, jobs, 3)
+}
+
+func Test_memoryBackend_RefreshFeeds_running(t *testing.T) {
+	b := newTestMemoryBackend()
+	b.refreshing = 1
+
+	skipped := func() int64 {
+		if v, ok := varMicrosub.Get("RefreshFeeds.skipped").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+
+	before := skipped()
+	// returns without loading feeds from the database
+	b.RefreshFeeds(context.Background())
+	assert.Equal(t, before+1, skipped())
+}
+
+func Test_responseFetchHint(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		want   time.Duration
+	}{
+		{"max-age", 200, http.Header{"Cache-Control": {"public, max-age=3600"}}, time.Hour},
+		{"no-cache", 200, http.Header{"Cache-Control": {"max-age=3600, no-cache"}}, 0},
+		{"not modified", 304, http.Header{"Cache-Control": {"max-age=60"}}, time.Minute},
+		{"retry after seconds", 429, http.Header{"Retry-After": {"120"}}, 2 * time.Minute},
+		{"retry after date", 503, http.Header{"Retry-After": {"Sat, 01 Jan 2022 14:00:00 GMT"}}, 2 * time.Hour},
+		{"retry after invalid", 503, http.Header{"Retry-After": {"soon"}}, 0},
+		{"retry after ignored", 200, http.Header{"Retry-After": {"120"}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: tt.header}
+			assert.Equal(t, tt.want, responseFetchHint(resp, now))
+		})
+	}
+}
+
+func Test_feed_applyFetchHint(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	f := feed{NextFetchAt: now.Add(time.Hour)}
+	f.applyFetchHint(time.Minute, now)
+	assert.Equal(t, now.Add(time.Hour), f.NextFetchAt)
+
+	f.applyFetchHint(2*time.Hour, now)
+	assert.Equal(t, now.Add(2*time.Hour), f.NextFetchAt)
+
+	f.applyFetchHint(30*24*time.Hour, now)
+	assert.Equal(t, now.Add(maxFetchHint), f.NextFetchAt)
+}
+
+func Test_fetchHint(t *testing.T) {
+	assert.Equal(t, 2*time.Hour, fetchHint(2*time.Hour, time.Hour))
+	assert.Equal(t, 3*time.Hour, fetchHint(2*time.Hour, 3*time.Hour))
+	assert.Equal(t, maxFetchHint, fetchHint(30*24*time.Hour, 0))
+
+	// the interval of feeds that update yearly or have a ttl of a year is
+	// limited to a day
+	for name, channel := range map[string]string{
+		"yearly": `<sy:updatePeriod>yearly</sy:updatePeriod>`,
+		"ttl":    `<ttl>525600</ttl>`,
+	} {
+		body := `<?xml version="1.0"?>
+<rss version="2.0" xmlns:sy="http://purl.org/rss/1.0/modules/syndication/">
+<channel><title>Test</title><link>https://example.com/</link>` + channel + `</channel>
+</rss>`
+		_, interval, err := processSourcedItems(nil, "https://example.com/feed.xml", "application/rss+xml", strings.NewReader(body))
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		assert.Equal(t, 365*24*time.Hour, interval, name)
+
+		now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+		f := feed{NextFetchAt: now.Add(time.Hour)}
+		f.applyFetchHint(fetchHint(0, interval), now)
+		assert.Equal(t, now.Add(maxFetchHint), f.NextFetchAt, name)
+	}
+}
+
+func Test_permanentRedirect(t *testing.T) {
+	allowLoopback(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/moved", http.StatusMovedPermanently)
+		case "/moved":
+			http.Redirect(w, r, "/new", http.StatusPermanentRedirect)
+		case "/temporary":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		case "/mixed":
+			http.Redirect(w, r, "/temporary", http.StatusMovedPermanently)
+		case "/broken":
+			http.Redirect(w, r, "/missing", http.StatusMovedPermanently)
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte("feed"))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path    string
+		movedTo string
+	}{
+		{"/new", ""},
+		{"/old", "/new"},
+		{"/temporary", ""},
+		{"/mixed", "/temporary"},
+		{"/broken", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := Fetch2(context.Background(), server.URL+tt.path)
+			if !assert.NoError(t, err) {
+				return
+			}
+			resp.Body.Close()
+
+			movedTo, ok := permanentRedirect(resp)
+			assert.Equal(t, tt.movedTo != "", ok)
+			if ok {
+				assert.Equal(t, server.URL+tt.movedTo, movedTo)
+			}
+		})
+	}
+}
+
+func Test_failureBackoff(t *testing.T) {
+	assert.Equal(t, 5*time.Minute, failureBackoff(1))
+	assert.Equal(t, 10*time.Minute, failureBackoff(2))
+	assert.Equal(t, 40*time.Minute, failureBackoff(4))
+	assert.Equal(t, maxFetchHint, failureBackoff(10))
+	assert.Equal(t, maxFetchHint, failureBackoff(1000))
+}
+
+func Test_feed_failed(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	f := feed{Failures: 1, LastStatus: 200}
+
+	f.failed(fmt.Errorf("while fetching: %w", &statusError{StatusCode: 404, Status: "404 Not Found"}), now)
+	assert.Equal(t, 2, f.Failures)
+	assert.Equal(t, 404, f.LastStatus)
+	assert.Equal(t, "while fetching: unexpected status 404 Not Found", f.LastError)
+	assert.Equal(t, now.Add(10*time.Minute), f.NextFetchAt)
+
+	f.failed(errors.New("connection refused"), now)
+	assert.Equal(t, 3, f.Failures)
+	assert.Equal(t, 0, f.LastStatus)
+	assert.Equal(t, now.Add(20*time.Minute), f.NextFetchAt)
+}
+
+func Test_memoryBackend_refreshFeed_busy(t *testing.T) {
+	allowLoopback(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1800")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	b := newTestMemoryBackend()
+	f := feed{ID: 1, URL: server.URL, Channels: []string{"test-busy"}}
+
+	// The Retry-After of 30 minutes is used until the backoff is longer
+	for _, next := range []time.Duration{30 * time.Minute, 30 * time.Minute, 30 * time.Minute, 40 * time.Minute} {
+		err := b.refreshFeed(context.Background(), f)
+		var se *statusError
+		if !assert.True(t, errors.As(err, &se)) {
+			return
+		}
+		assert.Equal(t, http.StatusServiceUnavailable, se.StatusCode)
+
+		now := time.Now()
+		f.failed(err, now)
+		assert.Equal(t, now.Add(next), f.NextFetchAt)
+	}
+	assert.Equal(t, 4, f.Failures)
+	assert.Equal(t, http.StatusServiceUnavailable, f.LastStatus)
+}
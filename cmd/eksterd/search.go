@@ -50,10 +50,16 @@ type indexItem struct {
 	Channel string `json:"channel"`
 }
 
+// searchID returns the id of the item in the search index. Items are unique
+// per channel, so the same item can be indexed for multiple channels.
+func searchID(channel, itemID string) string {
+	return channel + ":" + itemID
+}
+
 func addToSearch(item microsub.Item, channel string) error {
 	if index != nil {
 		indexItem := indexItem{item, channel}
-		err := index.Index(item.ID, indexItem)
+		err := index.Index(searchID(channel, item.ID), indexItem)
 		if err != nil {
 			return fmt.Errorf("while indexing item: %v", err)
 		}
@@ -61,6 +67,16 @@ func addToSearch(item microsub.Item, channel string) error {
 	return nil
 }
 
+func removeFromSearch(channel, itemID string) error {
+	if index != nil {
+		err := index.Delete(searchID(channel, itemID))
+		if err != nil {
+			return fmt.Errorf("while removing item from index: %v", err)
+		}
+	}
+	return nil
+}
+
 func getStringArray(fields map[string]interface{}, key string) []string {
 	if value, e := fields[key]; e {
 		if str, ok := value.([]string); ok {
@@ -101,7 +117,9 @@ func querySearch(channel, query string) ([]string, error) {
 	hits := res.Hits
 	var ids []string
 	for _, hit := range hits {
-		itemIDStr := hit.ID
+		// Items that were indexed before the index contained the channel
+		// use the item id as document id
+		itemIDStr := getString(hit.Fields, "_id", hit.ID)
 		ids = append(ids, itemIDStr)
 	}
 
@@ -27,6 +27,7 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/pstuifzand/ekster/pkg/microsub"
@@ -130,6 +131,7 @@ func (c *Client) microsubPostFormRequest(ctx context.Context, action string, arg
 	}
 
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
 	res, err := client.Do(req)
 
@@ -169,11 +171,20 @@ func (c *Client) ChannelsGetList(ctx context.Context) ([]microsub.Channel, error
 }
 
 // TimelineGet gets a timeline from a Microsub server
-func (c *Client) TimelineGet(ctx context.Context, before, after, channel string) (microsub.Timeline, error) {
+func (c *Client) TimelineGet(ctx context.Context, before, after, channel string, filter microsub.TimelineFilter) (microsub.Timeline, error) {
 	args := make(map[string]string)
 	args["after"] = after
 	args["before"] = before
 	args["channel"] = channel
+	if filter.Unread {
+		args["is_read"] = "false"
+	}
+	if filter.Source != "" {
+		args["source"] = filter.Source
+	}
+	if filter.Limit > 0 {
+		args["limit"] = strconv.Itoa(filter.Limit)
+	}
 	res, err := c.microsubGetRequest(ctx, "timeline", args)
 	if err != nil {
 		return microsub.Timeline{}, err
@@ -299,6 +310,24 @@ func (c *Client) ChannelsDelete(ctx context.Context, uid string) error {
 	return nil
 }
 
+// ChannelsOrder changes the order of the channels.
+func (c *Client) ChannelsOrder(ctx context.Context, uids []string) error {
+	args := make(map[string]string)
+	args["method"] = "order"
+
+	data := url.Values{}
+	for _, uid := range uids {
+		data.Add("channels[]", uid)
+	}
+
+	res, err := c.microsubPostFormRequest(ctx, "channels", args, data)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
 // FollowURL follows a url.
 func (c *Client) FollowURL(ctx context.Context, channel, url string) (microsub.Feed, error) {
 	args := make(map[string]string)
@@ -393,6 +422,204 @@ func (c *Client) MarkRead(ctx context.Context, channel string, uids []string) er
 	return nil
 }
 
+// MarkReadUntil marks all items up to and including lastReadEntry read on the server.
+func (c *Client) MarkReadUntil(ctx context.Context, channel, lastReadEntry string) error {
+	args := make(map[string]string)
+	args["channel"] = channel
+	args["method"] = "mark_read"
+
+	data := url.Values{}
+	data.Add("last_read_entry", lastReadEntry)
+
+	res, err := c.microsubPostFormRequest(ctx, "timeline", args, data)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// MarkUnread marks an item unread on the server.
+func (c *Client) MarkUnread(ctx context.Context, channel string, uids []string) error {
+	args := make(map[string]string)
+	args["channel"] = channel
+	args["method"] = "mark_unread"
+
+	data := url.Values{}
+	for _, uid := range uids {
+		data.Add("entry[]", uid)
+	}
+
+	res, err := c.microsubPostFormRequest(ctx, "timeline", args, data)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// RemoveItems removes items from a channel on the server.
+func (c *Client) RemoveItems(ctx context.Context, channel string, uids []string) error {
+	args := make(map[string]string)
+	args["channel"] = channel
+	args["method"] = "remove"
+
+	data := url.Values{}
+	for _, uid := range uids {
+		data.Add("entry[]", uid)
+	}
+
+	res, err := c.microsubPostFormRequest(ctx, "timeline", args, data)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// StarItems stars items in a channel on the server.
+func (c *Client) StarItems(ctx context.Context, channel string, uids []string) error {
+	args := make(map[string]string)
+	args["channel"] = channel
+	args["method"] = "star"
+
+	data := url.Values{}
+	for _, uid := range uids {
+		data.Add("entry[]", uid)
+	}
+
+	res, err := c.microsubPostFormRequest(ctx, "timeline", args, data)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// UnstarItems unstars items in a channel on the server.
+func (c *Client) UnstarItems(ctx context.Context, channel string, uids []string) error {
+	args := make(map[string]string)
+	args["channel"] = channel
+	args["method"] = "unstar"
+
+	data := url.Values{}
+	for _, uid := range uids {
+		data.Add("entry[]", uid)
+	}
+
+	res, err := c.microsubPostFormRequest(ctx, "timeline", args, data)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// MuteGetList gets the list of muted users in a channel.
+func (c *Client) MuteGetList(ctx context.Context, channel string) ([]microsub.Card, error) {
+	args := make(map[string]string)
+	args["channel"] = channel
+	res, err := c.microsubGetRequest(ctx, "mute", args)
+	if err != nil {
+		return []microsub.Card{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return []microsub.Card{}, fmt.Errorf("HTTP Status is not 200, but %d, error while reading body", res.StatusCode)
+		}
+		return []microsub.Card{}, fmt.Errorf("HTTP Status is not 200, but %d: %s", res.StatusCode, body)
+	}
+	dec := json.NewDecoder(res.Body)
+	type muteResponse struct {
+		Items []microsub.Card `json:"items"`
+	}
+	var response muteResponse
+	err = dec.Decode(&response)
+	if err != nil {
+		return []microsub.Card{}, err
+	}
+	return response.Items, nil
+}
+
+// Mute mutes a user in a channel.
+func (c *Client) Mute(ctx context.Context, channel, url string) error {
+	args := make(map[string]string)
+	args["channel"] = channel
+	args["url"] = url
+	res, err := c.microsubPostRequest(ctx, "mute", args)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Unmute unmutes a user in a channel.
+func (c *Client) Unmute(ctx context.Context, channel, url string) error {
+	args := make(map[string]string)
+	args["channel"] = channel
+	args["url"] = url
+	res, err := c.microsubPostRequest(ctx, "unmute", args)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// BlockGetList gets the list of blocked users.
+func (c *Client) BlockGetList(ctx context.Context) ([]microsub.Card, error) {
+	args := make(map[string]string)
+	res, err := c.microsubGetRequest(ctx, "block", args)
+	if err != nil {
+		return []microsub.Card{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return []microsub.Card{}, fmt.Errorf("HTTP Status is not 200, but %d, error while reading body", res.StatusCode)
+		}
+		return []microsub.Card{}, fmt.Errorf("HTTP Status is not 200, but %d: %s", res.StatusCode, body)
+	}
+	dec := json.NewDecoder(res.Body)
+	type blockResponse struct {
+		Items []microsub.Card `json:"items"`
+	}
+	var response blockResponse
+	err = dec.Decode(&response)
+	if err != nil {
+		return []microsub.Card{}, err
+	}
+	return response.Items, nil
+}
+
+// Block blocks a user.
+func (c *Client) Block(ctx context.Context, url string) error {
+	args := make(map[string]string)
+	args["url"] = url
+	res, err := c.microsubPostRequest(ctx, "block", args)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Unblock unblocks a user.
+func (c *Client) Unblock(ctx context.Context, url string) error {
+	args := make(map[string]string)
+	args["url"] = url
+	res, err := c.microsubPostRequest(ctx, "unblock", args)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
 // Events open an event channel to the server.
 func (c *Client) Events(ctx context.Context) (chan sse.Message, error) {
 
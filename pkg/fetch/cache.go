@@ -0,0 +1,356 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxCacheSize is the size in bytes of the largest body that is
+	// cached, when MaxSize is not set
+	DefaultMaxCacheSize = 1 << 20
+
+	// DefaultKeepStale is how long stale responses are kept for revalidation,
+	// when KeepStale is not set
+	DefaultKeepStale = 24 * time.Hour
+
+	// maxHeuristicLifetime limits the freshness lifetime that is derived from
+	// Last-Modified
+	maxHeuristicLifetime = 24 * time.Hour
+)
+
+// Doer sends an HTTP request, *http.Client is a Doer
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// DoerFunc is a function that sends an HTTP request
+type DoerFunc func(req *http.Request) (*http.Response, error)
+
+// Do sends an HTTP request
+func (f DoerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// CachingFetcher is a Fetcher that keeps responses in a CacheStore, following
+// the rules for a private HTTP cache from RFC 7234. Only cacheable responses
+// are stored. Fresh responses are served from the store and stale responses
+// are revalidated with a conditional request.
+type CachingFetcher struct {
+	doer  Doer
+	store CacheStore
+
+	// MaxSize is the size in bytes of the largest body that is cached
+	MaxSize int64
+
+	// KeepStale is how long a response with a validator is kept after it
+	// becomes stale, so it can be revalidated
+	KeepStale time.Duration
+
+	now func() time.Time
+}
+
+// NewCachingFetcher creates a Fetcher that sends requests with doer and keeps
+// the responses in store
+func NewCachingFetcher(doer Doer, store CacheStore) *CachingFetcher {
+	return &CachingFetcher{
+		doer:      doer,
+		store:     store,
+		MaxSize:   DefaultMaxCacheSize,
+		KeepStale: DefaultKeepStale,
+		now:       time.Now,
+	}
+}
+
+// Fetch fetches an url and returns a response or error
+func (cf *CachingFetcher) Fetch(url string) (*http.Response, error) {
+	return cf.FetchWithContext(context.Background(), url)
+}
+
+// FetchWithContext fetches an url and returns a response or error
+func (cf *CachingFetcher) FetchWithContext(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	key := req.URL.String()
+	now := cf.now()
+
+	entry, err := cf.load(ctx, key)
+	if err != nil && err != ErrCacheMiss {
+		log.Printf("while loading %s from cache: %v", key, err)
+	}
+
+	if entry != nil {
+		if entry.age(now) < entry.lifetime() {
+			return entry.response(req, now), nil
+		}
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := cf.doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		entry.revalidated(resp.Header, now)
+		cf.save(ctx, key, entry, now)
+		return entry.response(req, now), nil
+	}
+
+	return cf.storeResponse(ctx, key, req, resp, now)
+}
+
+// storeResponse keeps resp in the store, when it is cacheable and not larger
+// than MaxSize
+func (cf *CachingFetcher) storeResponse(ctx context.Context, key string, req *http.Request, resp *http.Response, now time.Time) (*http.Response, error) {
+	if !cacheable(resp, now) {
+		return resp, nil
+	}
+
+	maxSize := cf.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxCacheSize
+	}
+	if resp.ContentLength > maxSize {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	entry := &cacheEntry{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       body,
+		StoredAt:   now,
+	}
+	cf.save(ctx, key, entry, now)
+
+	return entry.response(req, now), nil
+}
+
+func (cf *CachingFetcher) load(ctx context.Context, key string) (*cacheEntry, error) {
+	data, err := cf.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("while decoding cache entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// save keeps the entry in the store for as long as it is fresh, and for
+// KeepStale longer when it can be revalidated
+func (cf *CachingFetcher) save(ctx context.Context, key string, entry *cacheEntry, now time.Time) {
+	ttl := entry.lifetime() - entry.age(now)
+	if entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != "" {
+		keepStale := cf.KeepStale
+		if keepStale <= 0 {
+			keepStale = DefaultKeepStale
+		}
+		ttl += keepStale
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("while encoding cache entry for %s: %v", key, err)
+		return
+	}
+	if err := cf.store.Set(ctx, key, data, ttl); err != nil {
+		log.Printf("while saving %s in cache: %v", key, err)
+	}
+}
+
+// cacheEntry is a response in the cache
+type cacheEntry struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	StoredAt   time.Time   `json:"stored_at"`
+}
+
+// age returns the current age of the response (RFC 7234 section 4.2.3)
+func (entry *cacheEntry) age(now time.Time) time.Duration {
+	var age time.Duration
+	if seconds, err := strconv.Atoi(entry.Header.Get("Age")); err == nil && seconds > 0 {
+		age = time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(entry.Header.Get("Date")); err == nil && entry.StoredAt.Sub(date) > age {
+		age = entry.StoredAt.Sub(date)
+	}
+	return age + now.Sub(entry.StoredAt)
+}
+
+// lifetime returns the freshness lifetime of the response (RFC 7234 section
+// 4.2.1)
+func (entry *cacheEntry) lifetime() time.Duration {
+	return freshnessLifetime(entry.Header, entry.StoredAt)
+}
+
+// revalidated updates the entry with the header of a 304 Not Modified response
+// (RFC 7234 section 4.3.4)
+func (entry *cacheEntry) revalidated(header http.Header, now time.Time) {
+	for name, values := range header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Content-Range":
+			continue
+		}
+		entry.Header[name] = values
+	}
+	entry.Header.Del("Age")
+	entry.StoredAt = now
+}
+
+// response creates a response for req from the entry
+func (entry *cacheEntry) response(req *http.Request, now time.Time) *http.Response {
+	header := entry.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(entry.age(now).Seconds())))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.StatusCode, http.StatusText(entry.StatusCode)),
+		StatusCode:    entry.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(entry.Body)),
+		ContentLength: int64(len(entry.Body)),
+		Request:       req,
+	}
+}
+
+// cacheable returns true when the response can be stored (RFC 7234 section 3).
+// Responses that are never fresh and can't be revalidated are not stored.
+func cacheable(resp *http.Response, now time.Time) bool {
+	if resp.Request != nil && resp.Request.Method != http.MethodGet {
+		return false
+	}
+
+	// Status codes that are cacheable by default (RFC 7231 section 6.1)
+	switch resp.StatusCode {
+	case 200, 203, 204, 300, 301, 404, 405, 410, 414, 501:
+	default:
+		return false
+	}
+
+	if _, e := parseCacheControl(resp.Header.Get("Cache-Control"))["no-store"]; e {
+		return false
+	}
+	if strings.Contains(resp.Header.Get("Vary"), "*") {
+		return false
+	}
+
+	if resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != "" {
+		return true
+	}
+	return freshnessLifetime(resp.Header, now) > 0
+}
+
+// freshnessLifetime returns how long a response with header is fresh. The
+// lifetime is taken from max-age, Expires or, as a heuristic, 10% of the time
+// since Last-Modified.
+func freshnessLifetime(header http.Header, storedAt time.Time) time.Duration {
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	if _, e := cc["no-cache"]; e {
+		return 0
+	}
+	if value, e := cc["max-age"]; e {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		date = storedAt
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil || !t.After(date) {
+			return 0
+		}
+		return t.Sub(date)
+	}
+
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil && lastModified.Before(date) {
+		lifetime := date.Sub(lastModified) / 10
+		if lifetime > maxHeuristicLifetime {
+			lifetime = maxHeuristicLifetime
+		}
+		return lifetime
+	}
+
+	return 0
+}
+
+// parseCacheControl parses the directives of a Cache-Control header. The names
+// of the directives are lower case.
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg := part, ""
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			name, arg = part[:i], strings.Trim(strings.TrimSpace(part[i+1:]), `"`)
+		}
+		directives[strings.ToLower(strings.TrimSpace(name))] = arg
+	}
+	return directives
+}
@@ -0,0 +1,241 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fetch
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestServer returns a server that responds with body and the headers from
+// header. The number of requests is counted in requests.
+func newTestServer(header http.Header, body string, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if etag := header.Get("ETag"); etag != "" && r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.Write([]byte(body))
+	}))
+}
+
+func fetchBody(t *testing.T, fetcher Fetcher, url string) string {
+	resp, err := fetcher.Fetch(url)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+func TestCachingFetcher_Fresh(t *testing.T) {
+	requests := 0
+	server := newTestServer(http.Header{"Cache-Control": {"max-age=60"}}, "hello", &requests)
+	defer server.Close()
+
+	cf := NewCachingFetcher(http.DefaultClient, NewMemoryStore(1024))
+	assert.Equal(t, "hello", fetchBody(t, cf, server.URL))
+	assert.Equal(t, "hello", fetchBody(t, cf, server.URL))
+	assert.Equal(t, 1, requests)
+}
+
+func TestCachingFetcher_NoStore(t *testing.T) {
+	requests := 0
+	server := newTestServer(http.Header{"Cache-Control": {"no-store, max-age=60"}}, "hello", &requests)
+	defer server.Close()
+
+	cf := NewCachingFetcher(http.DefaultClient, NewMemoryStore(1024))
+	assert.Equal(t, "hello", fetchBody(t, cf, server.URL))
+	assert.Equal(t, "hello", fetchBody(t, cf, server.URL))
+	assert.Equal(t, 2, requests)
+}
+
+func TestCachingFetcher_Revalidate(t *testing.T) {
+	requests := 0
+	server := newTestServer(http.Header{"Cache-Control": {"no-cache"}, "Etag": {`"v1"`}}, "hello", &requests)
+	defer server.Close()
+
+	cf := NewCachingFetcher(http.DefaultClient, NewMemoryStore(1024))
+	assert.Equal(t, "hello", fetchBody(t, cf, server.URL))
+
+	resp, err := cf.Fetch(server.URL)
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello", string(body))
+	}
+	assert.Equal(t, 2, requests)
+}
+
+func TestCachingFetcher_MaxSize(t *testing.T) {
+	requests := 0
+	body := strings.Repeat("x", 100)
+	server := newTestServer(http.Header{"Cache-Control": {"max-age=60"}}, body, &requests)
+	defer server.Close()
+
+	cf := NewCachingFetcher(http.DefaultClient, NewMemoryStore(1024))
+	cf.MaxSize = 10
+	assert.Equal(t, body, fetchBody(t, cf, server.URL))
+	assert.Equal(t, body, fetchBody(t, cf, server.URL))
+	assert.Equal(t, 2, requests)
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	date := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"none", http.Header{}, 0},
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=300"}}, 5 * time.Minute},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache, max-age=300"}}, 0},
+		{"expires", http.Header{"Date": {date.Format(http.TimeFormat)}, "Expires": {date.Add(time.Hour).Format(http.TimeFormat)}}, time.Hour},
+		{"last-modified", http.Header{"Date": {date.Format(http.TimeFormat)}, "Last-Modified": {date.Add(-10 * time.Hour).Format(http.TimeFormat)}}, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, freshnessLifetime(tt.header, date))
+		})
+	}
+}
+
+func testStore(t *testing.T, store CacheStore) {
+	ctx := context.Background()
+
+	_, err := store.Get(ctx, "a")
+	assert.Equal(t, ErrCacheMiss, err)
+
+	assert.NoError(t, store.Set(ctx, "a", []byte("data"), time.Minute))
+	data, err := store.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	assert.NoError(t, store.Set(ctx, "b", []byte("data"), -time.Minute))
+	_, err = store.Get(ctx, "b")
+	assert.Equal(t, ErrCacheMiss, err)
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(10)
+	testStore(t, store)
+
+	ctx := context.Background()
+	assert.NoError(t, store.Set(ctx, "c", []byte("1234567"), time.Minute))
+
+	// "a" was used least recently and is removed to make room for "c"
+	_, err := store.Get(ctx, "a")
+	assert.Equal(t, ErrCacheMiss, err)
+	_, err = store.Get(ctx, "c")
+	assert.NoError(t, err)
+}
+
+func TestDiskStore(t *testing.T) {
+	store, err := NewDiskStore(t.TempDir(), 1024)
+	if assert.NoError(t, err) {
+		testStore(t, store)
+	}
+}
+
+func TestDiskStore_MaxSize(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// every entry uses 18 bytes, two entries fit
+	store, err := NewDiskStore(dir, 40)
+	if !assert.NoError(t, err) {
+		return
+	}
+	value := []byte("0123456789")
+
+	assert.NoError(t, store.Set(ctx, "a", value, time.Minute))
+	assert.NoError(t, store.Set(ctx, "b", value, time.Minute))
+	past := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(store.filename("a"), past, past))
+	assert.NoError(t, os.Chtimes(store.filename("b"), past.Add(time.Minute), past.Add(time.Minute)))
+
+	// "b" was used least recently and is removed to make room for "c"
+	_, err = store.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.NoError(t, store.Set(ctx, "c", value, time.Minute))
+
+	_, err = store.Get(ctx, "b")
+	assert.Equal(t, ErrCacheMiss, err)
+	_, err = store.Get(ctx, "a")
+	assert.NoError(t, err)
+	_, err = store.Get(ctx, "c")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(36), store.size)
+
+	// entries larger than the cache are not kept
+	assert.NoError(t, store.Set(ctx, "d", make([]byte, 40), time.Minute))
+	_, err = store.Get(ctx, "d")
+	assert.Equal(t, ErrCacheMiss, err)
+
+	// expired files are removed when the store is opened
+	assert.NoError(t, store.Set(ctx, "a", value, -time.Minute))
+	store, err = NewDiskStore(dir, 40)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(18), store.size)
+		_, err = os.Stat(store.filename("a"))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestDiskStore_foreignFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	foreign := []string{"notes.txt", "0123", strings.Repeat("a", 63), strings.Repeat("A", 64)}
+	for _, name := range foreign {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644))
+	}
+
+	store, err := NewDiskStore(dir, 40)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(0), store.size)
+
+	// a sweep only removes cache files
+	value := []byte("0123456789")
+	for _, key := range []string{"a", "b", "c"} {
+		assert.NoError(t, store.Set(ctx, key, value, time.Minute))
+	}
+	for _, name := range foreign {
+		_, err := os.Stat(filepath.Join(dir, name))
+		assert.NoError(t, err, name)
+	}
+}
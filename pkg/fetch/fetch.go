@@ -134,10 +134,19 @@ func FeedHeader(fetcher Fetcher, fetchURL, contentType string, body io.Reader) (
 
 // FeedItems returns the items from the url, parsed from body.
 func FeedItems(fetcher Fetcher, fetchURL, contentType string, body io.Reader) ([]microsub.Item, error) {
+	items, _, err := FeedItemsWithInterval(fetcher, fetchURL, contentType, body)
+	return items, err
+}
+
+// FeedItemsWithInterval returns the items from the url, parsed from body, and
+// the time between updates that an RSS feed advertises with <ttl> or
+// sy:updatePeriod. The interval is 0 for other feeds.
+func FeedItemsWithInterval(fetcher Fetcher, fetchURL, contentType string, body io.Reader) ([]microsub.Item, time.Duration, error) {
 	log.Printf("ProcessContent %s\n", fetchURL)
 	log.Println("Found " + contentType)
 
 	items := []microsub.Item{}
+	var interval time.Duration
 
 	u, _ := url.Parse(fetchURL)
 
@@ -162,7 +171,7 @@ func FeedItems(fetcher Fetcher, fetchURL, contentType string, body io.Reader) ([
 		var feed jsonfeed.Feed
 		err := json.NewDecoder(body).Decode(&feed)
 		if err != nil {
-			return items, fmt.Errorf("could not parse as jsonfeed: %v", err)
+			return items, interval, fmt.Errorf("could not parse as jsonfeed: %v", err)
 		}
 
 		author := &microsub.Card{}
@@ -202,11 +211,16 @@ func FeedItems(fetcher Fetcher, fetchURL, contentType string, body io.Reader) ([
 	} else if strings.HasPrefix(contentType, "text/xml") || strings.HasPrefix(contentType, "application/rss+xml") || strings.HasPrefix(contentType, "application/atom+xml") || strings.HasPrefix(contentType, "application/xml") {
 		body, err := ioutil.ReadAll(body)
 		if err != nil {
-			return items, fmt.Errorf("could not read feed for rss/atom: %v", err)
+			return items, interval, fmt.Errorf("could not read feed for rss/atom: %v", err)
 		}
 		feed, err := rss.Parse(body)
 		if err != nil {
-			return items, fmt.Errorf("while parsing rss/atom feed: %v", err)
+			return items, interval, fmt.Errorf("while parsing rss/atom feed: %v", err)
+		}
+
+		interval = feed.TTL
+		if updateInterval := feed.UpdateInterval(); updateInterval > interval {
+			interval = updateInterval
 		}
 
 		baseURL, _ := url.Parse(fetchURL)
@@ -242,7 +256,7 @@ func FeedItems(fetcher Fetcher, fetchURL, contentType string, body io.Reader) ([
 			items = append(items, item)
 		}
 	} else {
-		return items, fmt.Errorf("unknown content-type %s for url %s", contentType, fetchURL)
+		return items, interval, fmt.Errorf("unknown content-type %s for url %s", contentType, fetchURL)
 	}
 
 	for i, v := range items {
@@ -275,7 +289,7 @@ func FeedItems(fetcher Fetcher, fetchURL, contentType string, body io.Reader) ([
 		}
 	}
 
-	return items, nil
+	return items, interval, nil
 }
 
 type mention struct {
@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -50,3 +51,22 @@ func TestFeedHeader(t *testing.T) {
 		assert.Equal(t, "https://example.com/profile.jpg", feed.Photo)
 	}
 }
+
+func TestFeedItemsWithInterval(t *testing.T) {
+	doc := `<?xml version="1.0"?>
+<rss version="2.0" xmlns:sy="http://purl.org/rss/1.0/modules/syndication/">
+<channel><title>Test</title><ttl>30</ttl><sy:updatePeriod>hourly</sy:updatePeriod>
+<item><guid>https://example.com/1</guid><title>One</title><link>https://example.com/1</link></item>
+</channel>
+</rss>`
+	items, interval, err := FeedItemsWithInterval(FetcherFunc(fetcher), "https://example.com/feed.xml", "application/rss+xml", strings.NewReader(doc))
+	if assert.NoError(t, err) {
+		assert.Len(t, items, 1)
+		assert.Equal(t, time.Hour, interval)
+	}
+
+	_, interval, err = FeedItemsWithInterval(FetcherFunc(fetcher), "https://example.com/", "text/html", strings.NewReader("<html></html>"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Duration(0), interval)
+	}
+}
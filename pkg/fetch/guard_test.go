@@ -0,0 +1,100 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fetch
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_guard_check(t *testing.T) {
+	g := &guard{allowed: mustParseNetworks([]string{"10.1.0.0/16", "fd00::1"})}
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"93.184.216.34", false},
+		{"2606:2800:220:1::1", false},
+		{"127.0.0.1", true},
+		{"10.0.0.1", true},
+		{"10.1.2.3", false},
+		{"172.20.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.100.100.200", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"64:ff9b::7f00:1", true},
+		{"2002:7f00:1::1", true},
+		{"fe80::1", true},
+		{"fec0::1", true},
+		{"fd00:ec2::254", true},
+		{"fd00::1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			err := g.check(net.ParseIP(tt.ip))
+			if tt.blocked {
+				assert.True(t, errors.Is(err, ErrBlockedAddress))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewClient_blocksLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	_, err := NewClient(DefaultPolicy()).Get(server.URL)
+	assert.True(t, errors.Is(err, ErrBlockedAddress))
+
+	// "localhost" is resolved before the address is checked
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	_, err = NewClient(DefaultPolicy()).Get("http://localhost:" + port)
+	assert.True(t, errors.Is(err, ErrBlockedAddress))
+
+	policy := DefaultPolicy()
+	policy.AllowAddresses = []string{"127.0.0.0/8"}
+	resp, err := NewClient(policy).Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+
+	// a redirect to an internal address is blocked too
+	redirect := httptest.NewServer(http.RedirectHandler("http://169.254.169.254/latest/meta-data/", http.StatusFound))
+	defer redirect.Close()
+	_, err = NewClient(policy).Get(redirect.URL)
+	assert.True(t, errors.Is(err, ErrBlockedAddress))
+}
+
+func TestNewClient_noProxy(t *testing.T) {
+	client := NewClient(DefaultPolicy())
+	transport := client.Transport.(*policyTransport).base.(*http.Transport)
+	assert.Nil(t, transport.Proxy)
+}
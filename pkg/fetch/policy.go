@@ -0,0 +1,252 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fetch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrDenied is returned when the fetch policy does not allow the url
+	ErrDenied = errors.New("url denied by fetch policy")
+
+	// ErrBodyTooLarge is returned while reading a body that is larger than
+	// the MaxBodySize of the fetch policy
+	ErrBodyTooLarge = errors.New("response body too large")
+
+	// ErrTooManyRedirects is returned when a request is redirected more than
+	// MaxRedirects times
+	ErrTooManyRedirects = errors.New("too many redirects")
+)
+
+// Policy decides which urls are fetched and limits the requests
+type Policy struct {
+	// Allow contains host patterns. When it is not empty, only hosts that
+	// match one of the patterns are fetched.
+	Allow []string `json:"allow"`
+	// Deny contains host patterns of hosts that are never fetched
+	Deny []string `json:"deny"`
+	// AllowAddresses contains ip addresses and networks that may be fetched,
+	// even though they are loopback or private addresses
+	AllowAddresses []string `json:"allow_addresses"`
+
+	// UserAgent is sent with every request
+	UserAgent string `json:"user_agent"`
+	// MaxBodySize is the maximum size in bytes of a response body, 0 means
+	// no limit
+	MaxBodySize int64 `json:"max_body_size"`
+	// MaxRedirects is the number of redirects that are followed
+	MaxRedirects int `json:"max_redirects"`
+	// Timeout limits the time of a request including reading the body, 0
+	// means no timeout
+	Timeout Duration `json:"timeout"`
+}
+
+// Duration is a time.Duration that is written as a string like "30s" in JSON
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DefaultPolicy returns the policy that is used when none is configured
+func DefaultPolicy() Policy {
+	return Policy{
+		Deny:         []string{"reddit.com", "*.reddit.com", "twitter.com", "*.twitter.com"},
+		UserAgent:    "ekster (+https://github.com/pstuifzand/ekster)",
+		MaxBodySize:  10 << 20,
+		MaxRedirects: 10,
+		Timeout:      Duration(30 * time.Second),
+	}
+}
+
+// LoadPolicy reads a policy from a JSON file. Fields that are missing from the
+// file keep the value from DefaultPolicy.
+func LoadPolicy(filename string) (Policy, error) {
+	policy := DefaultPolicy()
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return policy, fmt.Errorf("while opening fetch policy: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		return policy, fmt.Errorf("while reading fetch policy %s: %w", filename, err)
+	}
+	if _, err := parseNetworks(policy.AllowAddresses); err != nil {
+		return policy, fmt.Errorf("while reading fetch policy %s: allow_addresses: %w", filename, err)
+	}
+	return policy, nil
+}
+
+// Check returns an error when the policy does not allow fetching u
+func (p Policy) Check(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: %s has no http(s) scheme", ErrDenied, u)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range p.Deny {
+		if matchHost(pattern, host) {
+			return fmt.Errorf("%w: %s", ErrDenied, host)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range p.Allow {
+		if matchHost(pattern, host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrDenied, host)
+}
+
+// matchHost returns true when host matches pattern. A pattern is a host name,
+// or "*." followed by a domain to match all subdomains of the domain.
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+// NewClient creates a http.Client that applies the policy to every request,
+// including the requests of redirects. The client does not connect to loopback,
+// private or link-local addresses, unless they are in AllowAddresses. Invalid
+// entries in AllowAddresses are ignored, LoadPolicy reports them.
+func NewClient(policy Policy) *http.Client {
+	g := &guard{}
+	for _, value := range policy.AllowAddresses {
+		if networks, err := parseNetworks([]string{value}); err == nil {
+			g.allowed = append(g.allowed, networks...)
+		}
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   g.control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// With a proxy the guard would only check the address of the proxy
+	transport.Proxy = nil
+
+	return &http.Client{
+		Transport: &policyTransport{
+			policy: policy,
+			base:   transport,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > policy.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			return nil
+		},
+		Timeout: time.Duration(policy.Timeout),
+	}
+}
+
+// policyTransport is a http.RoundTripper that checks the url, sets the user
+// agent and limits the size of the body
+type policyTransport struct {
+	policy Policy
+	base   http.RoundTripper
+}
+
+// RoundTrip sends the request, when the policy allows it
+func (t *policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.policy.Check(req.URL); err != nil {
+		return nil, err
+	}
+
+	if t.policy.UserAgent != "" && req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.policy.UserAgent)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if max := t.policy.MaxBodySize; max > 0 {
+		if resp.ContentLength > max {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%w: %d bytes", ErrBodyTooLarge, resp.ContentLength)
+		}
+		resp.Body = &limitedBody{body: resp.Body, remaining: max}
+	}
+	return resp, nil
+}
+
+// limitedBody returns ErrBodyTooLarge when more than remaining bytes are read
+type limitedBody struct {
+	body      io.ReadCloser
+	remaining int64
+}
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	if lb.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	// Read one byte more than allowed to find out if the body is too large
+	if int64(len(p)) > lb.remaining+1 {
+		p = p[:lb.remaining+1]
+	}
+	n, err := lb.body.Read(p)
+	lb.remaining -= int64(n)
+	if lb.remaining < 0 {
+		return n + int(lb.remaining), ErrBodyTooLarge
+	}
+	return n, err
+}
+
+func (lb *limitedBody) Close() error {
+	return lb.body.Close()
+}
@@ -0,0 +1,130 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fetch
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	policy := Policy{
+		Allow: []string{"example.com", "*.example.org"},
+		Deny:  []string{"private.example.org"},
+	}
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://example.com/feed", true},
+		{"http://EXAMPLE.com:8080/", true},
+		{"https://www.example.com/", false},
+		{"https://blog.example.org/", true},
+		{"https://example.org/", false},
+		{"https://private.example.org/", false},
+		{"ftp://example.com/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			err := policy.Check(u)
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, ErrDenied))
+			}
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/redirect", http.StatusFound)
+		case "/large":
+			w.Write([]byte(strings.Repeat("x", 100)))
+		default:
+			w.Write([]byte(r.UserAgent()))
+		}
+	}))
+	defer server.Close()
+
+	policy := Policy{
+		AllowAddresses: []string{"127.0.0.1"},
+		UserAgent:      "test-agent",
+		MaxBodySize:    50,
+		MaxRedirects:   2,
+	}
+	client := NewClient(policy)
+
+	resp, err := client.Get(server.URL)
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "test-agent", string(body))
+	}
+
+	resp, err = client.Get(server.URL + "/large")
+	if err == nil {
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	assert.True(t, errors.Is(err, ErrBodyTooLarge))
+
+	_, err = client.Get(server.URL + "/redirect")
+	assert.True(t, errors.Is(err, ErrTooManyRedirects))
+
+	policy.Deny = []string{"127.0.0.1"}
+	_, err = NewClient(policy).Get(server.URL)
+	assert.True(t, errors.Is(err, ErrDenied))
+}
+
+func TestLoadPolicy(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(filename, []byte(`{"deny": ["example.com"], "timeout": "5s"}`), 0o644)
+	assert.NoError(t, err)
+
+	policy, err := LoadPolicy(filename)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"example.com"}, policy.Deny)
+		assert.Equal(t, Duration(5*time.Second), policy.Timeout)
+		assert.Equal(t, DefaultPolicy().UserAgent, policy.UserAgent)
+	}
+
+	err = os.WriteFile(filename, []byte(`{"block": ["example.com"]}`), 0o644)
+	assert.NoError(t, err)
+	_, err = LoadPolicy(filename)
+	assert.Error(t, err)
+
+	err = os.WriteFile(filename, []byte(`{"allow_addresses": ["10.0.0.0/33"]}`), 0o644)
+	assert.NoError(t, err)
+	_, err = LoadPolicy(filename)
+	assert.Error(t, err)
+}
@@ -0,0 +1,362 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fetch
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrCacheMiss is returned by a CacheStore when the key is not found
+var ErrCacheMiss = errors.New("cache miss")
+
+// CacheStore keeps the data of a CachingFetcher
+type CacheStore interface {
+	// Get returns the data for key, or ErrCacheMiss when it is not found
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set keeps the data for key for at most ttl
+	Set(ctx context.Context, key string, data []byte, ttl time.Duration) error
+}
+
+// RedisStore keeps the cached data in Redis
+type RedisStore struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+// NewRedisStore creates a CacheStore that keeps the data in Redis, with keys
+// that start with prefix
+func NewRedisStore(pool *redis.Pool, prefix string) *RedisStore {
+	return &RedisStore{pool: pool, prefix: prefix}
+}
+
+// Get returns the data for key
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", s.prefix+key))
+	if err == redis.ErrNil {
+		return nil, ErrCacheMiss
+	}
+	return data, err
+}
+
+// Set keeps the data for key
+func (s *RedisStore) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		return nil
+	}
+	_, err = conn.Do("SET", s.prefix+key, data, "PX", ms)
+	return err
+}
+
+// MemoryStore keeps the cached data in memory. When the data is larger than
+// the maximum size, the least recently used keys are removed.
+type MemoryStore struct {
+	lock    sync.Mutex
+	maxSize int64
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type memoryStoreEntry struct {
+	key     string
+	data    []byte
+	expires time.Time
+}
+
+// NewMemoryStore creates a CacheStore that keeps at most maxSize bytes of data
+// in memory
+func NewMemoryStore(maxSize int64) *MemoryStore {
+	return &MemoryStore{
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the data for key
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	entry := elem.Value.(*memoryStoreEntry)
+	if !time.Now().Before(entry.expires) {
+		s.remove(elem)
+		return nil, ErrCacheMiss
+	}
+	s.lru.MoveToFront(elem)
+	return entry.data, nil
+}
+
+// Set keeps the data for key
+func (s *MemoryStore) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+	if int64(len(data)) > s.maxSize {
+		return nil
+	}
+
+	entry := &memoryStoreEntry{key: key, data: data, expires: time.Now().Add(ttl)}
+	s.entries[key] = s.lru.PushFront(entry)
+	s.size += int64(len(data))
+
+	for s.size > s.maxSize {
+		s.remove(s.lru.Back())
+	}
+	return nil
+}
+
+func (s *MemoryStore) remove(elem *list.Element) {
+	entry := s.lru.Remove(elem).(*memoryStoreEntry)
+	delete(s.entries, entry.key)
+	s.size -= int64(len(entry.data))
+}
+
+// DiskStore keeps the cached data in files in a directory. When the files are
+// larger than the maximum size, the expired files and then the least recently
+// used files are removed. Files in the directory that are not named like cache
+// files are left alone.
+type DiskStore struct {
+	dir     string
+	maxSize int64
+
+	lock sync.Mutex
+	size int64
+}
+
+// diskStoreHeader is the size of the expiry time at the start of each file
+const diskStoreHeader = 8
+
+// tmpPrefix starts the names of files that are being written
+const tmpPrefix = "tmp-"
+
+// NewDiskStore creates a CacheStore that keeps at most maxSize bytes of data
+// in files in dir
+func NewDiskStore(dir string, maxSize int64) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("while creating cache directory: %w", err)
+	}
+	s := &DiskStore{dir: dir, maxSize: maxSize}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.sweep(maxSize); err != nil {
+		return nil, fmt.Errorf("while reading cache directory: %w", err)
+	}
+	return s, nil
+}
+
+func (s *DiskStore) filename(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(h[:]))
+}
+
+// isCacheFile returns true when name is the name of a cache file, which is the
+// hex encoded sha256 of the key
+func isCacheFile(name string) bool {
+	if len(name) != 2*sha256.Size {
+		return false
+	}
+	for _, c := range name {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns the data for key
+func (s *DiskStore) Get(ctx context.Context, key string) ([]byte, error) {
+	filename := s.filename(key)
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The file starts with the expiry time in Unix nanoseconds
+	if len(data) < diskStoreHeader || !time.Now().Before(parseExpiry(data)) {
+		s.lock.Lock()
+		s.remove(filename)
+		s.lock.Unlock()
+		return nil, ErrCacheMiss
+	}
+
+	// The modification time is the last use of the file
+	now := time.Now()
+	_ = os.Chtimes(filename, now, now)
+
+	return data[diskStoreHeader:], nil
+}
+
+// Set keeps the data for key
+func (s *DiskStore) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	size := int64(diskStoreHeader + len(data))
+	if size > s.maxSize {
+		s.lock.Lock()
+		s.remove(s.filename(key))
+		s.lock.Unlock()
+		return nil
+	}
+
+	f, err := ioutil.TempFile(s.dir, tmpPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	var header [diskStoreHeader]byte
+	binary.BigEndian.PutUint64(header[:], uint64(time.Now().Add(ttl).UnixNano()))
+	if _, err := f.Write(header[:]); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	filename := s.filename(key)
+	s.remove(filename)
+	if err := os.Rename(f.Name(), filename); err != nil {
+		return err
+	}
+	s.size += size
+
+	// Remove more than needed, so not every Set has to read the directory
+	if s.size > s.maxSize {
+		return s.sweep(s.maxSize * 9 / 10)
+	}
+	return nil
+}
+
+// remove removes the file and subtracts its size. The lock must be held.
+func (s *DiskStore) remove(filename string) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(filename); err == nil {
+		s.size -= info.Size()
+	}
+}
+
+// sweep removes the expired files, and the least recently used files until
+// the total size is at most maxSize. It recalculates the total size. The lock
+// must be held.
+func (s *DiskStore) sweep(maxSize int64) error {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var files []os.FileInfo
+	var size int64
+	for _, info := range infos {
+		if info.IsDir() || !isCacheFile(info.Name()) {
+			continue
+		}
+		filename := filepath.Join(s.dir, info.Name())
+		expires, err := readExpiry(filename)
+		if err != nil || !now.Before(expires) {
+			_ = os.Remove(filename)
+			continue
+		}
+		files = append(files, info)
+		size += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, info := range files {
+		if size <= maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(s.dir, info.Name())); err == nil {
+			size -= info.Size()
+		}
+	}
+
+	s.size = size
+	return nil
+}
+
+// readExpiry reads the expiry time from the header of the file
+func readExpiry(filename string) (time.Time, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	var header [diskStoreHeader]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return time.Time{}, err
+	}
+	return parseExpiry(header[:]), nil
+}
+
+func parseExpiry(data []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data[:diskStoreHeader])))
+}
@@ -54,25 +54,21 @@ type TokenResponse struct {
 	ErrorDescription string `json:"error_description"`
 }
 
-// GetEndpoints returns the endpoints for the me url
-func GetEndpoints(me *url.URL) (Endpoints, error) {
+// GetEndpoints returns the endpoints for the me url, the page is fetched with client
+func GetEndpoints(client *http.Client, me *url.URL) (Endpoints, error) {
 	var endpoints Endpoints
 	endpoints.Me = me.String()
 
 	baseURL := me
 
-	res, err := http.Get(me.String())
+	res, err := client.Get(me.String())
 	if err != nil {
 		return endpoints, err
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return endpoints, fmt.Errorf("error from endpoint while reading body %d", res.StatusCode)
-		}
-		return endpoints, fmt.Errorf("error from endpoint %d: %s", res.StatusCode, body)
+		return endpoints, fmt.Errorf("error from endpoint %d", res.StatusCode)
 	}
 
 	var links linkheader.Links
@@ -112,8 +108,9 @@ func GetEndpoints(me *url.URL) (Endpoints, error) {
 	return endpoints, nil
 }
 
-// Authorize allows you to get the token from Indieauth through the command line
-func Authorize(me *url.URL, endpoints Endpoints, clientID, scope string) (TokenResponse, error) {
+// Authorize allows you to get the token from Indieauth through the command
+// line. The token is requested from the token endpoint with client.
+func Authorize(client *http.Client, me *url.URL, endpoints Endpoints, clientID, scope string) (TokenResponse, error) {
 	var tokenResponse TokenResponse
 
 	authURL, err := url.Parse(endpoints.AuthorizationEndpoint)
@@ -185,7 +182,7 @@ func Authorize(me *url.URL, endpoints Endpoints, clientID, scope string) (TokenR
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("Accept", "application/json")
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := client.Do(req)
 	if err != nil {
 		return tokenResponse, err
 	}
@@ -22,6 +22,7 @@ package microsub
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/pstuifzand/ekster/pkg/sse"
@@ -37,6 +38,12 @@ import (
 	block / unblock
 */
 
+// ErrChannelNotFound is returned when a channel uid does not exist
+var ErrChannelNotFound = errors.New("channel not found")
+
+// ErrEntryNotFound is returned when an entry uid does not exist in a channel
+var ErrEntryNotFound = errors.New("entry not found")
+
 // Constants for Unread
 const (
 	UnreadBool  = 0
@@ -102,7 +109,9 @@ type Item struct {
 	Refs       map[string]Item `json:"refs,omitempty"`
 	ID         string          `json:"_id,omitempty"`
 	Read       bool            `json:"_is_read"`
+	Starred    bool            `json:"_is_starred,omitempty"`
 	Source     *Source         `json:"_source,omitempty"`
+	Channel    string          `json:"_channel,omitempty"`
 }
 
 // Source is an Item source
@@ -125,6 +134,16 @@ type Timeline struct {
 	Paging Pagination `json:"paging"`
 }
 
+// TimelineFilter contains the optional filters for a timeline
+type TimelineFilter struct {
+	// Unread only returns the items that are not read yet
+	Unread bool
+	// Source only returns the items from the feed with this id
+	Source string
+	// Limit is the maximum number of items in a page, zero uses the default
+	Limit int
+}
+
 // Feed is one microsub feed.
 type Feed struct {
 	Type        string `json:"type"`
@@ -133,6 +152,26 @@ type Feed struct {
 	Photo       string `json:"photo,omitempty"`
 	Description string `json:"description,omitempty"`
 	Author      Card   `json:"author,omitempty"`
+
+	// Status is the result of the last fetches of the feed
+	Status *FeedStatus `json:"_status,omitempty"`
+}
+
+// FeedStatus contains the result of the last fetches of a feed. It is an
+// ekster extension, that is returned as "_status" in the follow list. The times
+// are formatted as RFC 3339.
+type FeedStatus struct {
+	LastSuccess         string `json:"last_success,omitempty"`
+	LastError           string `json:"last_error,omitempty"`
+	LastErrorAt         string `json:"last_error_at,omitempty"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LastStatus          int    `json:"last_status,omitempty"`
+	Dead                bool   `json:"dead,omitempty"`
+}
+
+// Healthy returns true when the last fetch of the feed succeeded
+func (s FeedStatus) Healthy() bool {
+	return s.ConsecutiveFailures == 0 && !s.Dead
 }
 
 // Microsub is the main protocol that should be implemented by a backend
@@ -141,10 +180,16 @@ type Microsub interface {
 	ChannelsCreate(ctx context.Context, name string) (Channel, error)
 	ChannelsUpdate(ctx context.Context, uid, name string) (Channel, error)
 	ChannelsDelete(ctx context.Context, uid string) error
+	ChannelsOrder(ctx context.Context, uids []string) error
 
-	TimelineGet(ctx context.Context, before, after, channel string) (Timeline, error)
+	TimelineGet(ctx context.Context, before, after, channel string, filter TimelineFilter) (Timeline, error)
 
 	MarkRead(ctx context.Context, channel string, entry []string) error
+	MarkReadUntil(ctx context.Context, channel, lastReadEntry string) error
+	MarkUnread(ctx context.Context, channel string, entry []string) error
+	RemoveItems(ctx context.Context, channel string, entry []string) error
+	StarItems(ctx context.Context, channel string, entry []string) error
+	UnstarItems(ctx context.Context, channel string, entry []string) error
 
 	FollowGetList(ctx context.Context, uid string) ([]Feed, error)
 	FollowURL(ctx context.Context, uid string, url string) (Feed, error)
@@ -156,6 +201,14 @@ type Microsub interface {
 
 	ItemSearch(ctx context.Context, channel, query string) ([]Item, error)
 
+	MuteGetList(ctx context.Context, channel string) ([]Card, error)
+	Mute(ctx context.Context, channel, url string) error
+	Unmute(ctx context.Context, channel, url string) error
+
+	BlockGetList(ctx context.Context) ([]Card, error)
+	Block(ctx context.Context, url string) error
+	Unblock(ctx context.Context, url string) error
+
 	Events(ctx context.Context) (chan sse.Message, error)
 }
 
@@ -86,18 +86,21 @@ func FetchByFunc(fetchFunc FetchFunc, url string) (*Feed, error) {
 
 // Feed is the top-level structure.
 type Feed struct {
-	Nickname    string              `json:"nickname"` // This is not set by the package, but could be helpful.
-	Title       string              `json:"title"`
-	Description string              `json:"description"`
-	Link        string              `json:"link"`      // Link to the creator's website.
-	UpdateURL   string              `json:"updateurl"` // URL of the feed itself.
-	HubURL      string              `json:"huburl"`    // URL of the WebSub hub
-	Image       *Image              `json:"image"`     // Feed icon.
-	Items       []*Item             `json:"items"`
-	ItemMap     map[string]struct{} `json:"itemmap"` // Used in checking whether an item has been seen before.
-	Refresh     time.Time           `json:"refresh"` // Earliest time this feed should next be checked.
-	Unread      uint32              `json:"unread"`  // Number of unread items. Used by aggregators.
-	FetchFunc   FetchFunc           `json:"-"`
+	Nickname        string              `json:"nickname"` // This is not set by the package, but could be helpful.
+	Title           string              `json:"title"`
+	Description     string              `json:"description"`
+	Link            string              `json:"link"`      // Link to the creator's website.
+	UpdateURL       string              `json:"updateurl"` // URL of the feed itself.
+	HubURL          string              `json:"huburl"`    // URL of the WebSub hub
+	Image           *Image              `json:"image"`     // Feed icon.
+	Items           []*Item             `json:"items"`
+	ItemMap         map[string]struct{} `json:"itemmap"`         // Used in checking whether an item has been seen before.
+	Refresh         time.Time           `json:"refresh"`         // Earliest time this feed should next be checked.
+	TTL             time.Duration       `json:"ttl"`             // How long the feed can be cached, from <ttl>.
+	UpdatePeriod    string              `json:"updateperiod"`    // sy:updatePeriod: hourly, daily, weekly, monthly or yearly.
+	UpdateFrequency int                 `json:"updatefrequency"` // sy:updateFrequency: number of updates per UpdatePeriod.
+	Unread          uint32              `json:"unread"`          // Number of unread items. Used by aggregators.
+	FetchFunc       FetchFunc           `json:"-"`
 }
 
 type refreshError string
@@ -300,13 +303,13 @@ type Enclosure struct {
 	Length uint   `json:"length"`
 }
 
-// Get uses http.Get to fetch an enclosure.
-func (e *Enclosure) Get() (io.ReadCloser, error) {
+// Get uses client to fetch an enclosure.
+func (e *Enclosure) Get(client *http.Client) (io.ReadCloser, error) {
 	if e == nil || e.URL == "" {
 		return nil, errors.New("no enclosure")
 	}
 
-	res, err := http.Get(e.URL)
+	res, err := client.Get(e.URL)
 	if err != nil {
 		return nil, err
 	}
@@ -322,13 +325,13 @@ type Image struct {
 	Width  uint32 `json:"width"`
 }
 
-// Get uses http.Get to fetch an image.
-func (i *Image) Get() (io.ReadCloser, error) {
+// Get uses client to fetch an image.
+func (i *Image) Get(client *http.Client) (io.ReadCloser, error) {
 	if i == nil || i.URL == "" {
 		return nil, errors.New("no image")
 	}
 
-	res, err := http.Get(i.URL)
+	res, err := client.Get(i.URL)
 	if err != nil {
 		return nil, err
 	}
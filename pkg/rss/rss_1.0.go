@@ -31,6 +31,9 @@ func parseRSS1(data []byte) (*Feed, error) {
 	out.Description = channel.Description
 	out.Link = channel.Link
 	out.Image = channel.Image.Image()
+	out.TTL = time.Duration(channel.MinsToLive) * time.Minute
+	out.UpdatePeriod = channel.UpdatePeriod
+	out.UpdateFrequency = channel.UpdateFrequency
 
 	titleCaser := cases.Title(language.English)
 	if channel.MinsToLive != 0 {
@@ -135,6 +138,9 @@ type rss1_0Channel struct {
 	MinsToLive  int         `xml:"ttl"`
 	SkipHours   []int       `xml:"skipHours>hour"`
 	SkipDays    []string    `xml:"skipDays>day"`
+
+	UpdatePeriod    string `xml:"http://purl.org/rss/1.0/modules/syndication/ updatePeriod"`
+	UpdateFrequency int    `xml:"http://purl.org/rss/1.0/modules/syndication/ updateFrequency"`
 }
 
 type rss1_0Item struct {
@@ -39,6 +39,9 @@ func parseRSS2(data []byte) (*Feed, error) {
 	}
 
 	out.Image = channel.Image.Image()
+	out.TTL = time.Duration(channel.MinsToLive) * time.Minute
+	out.UpdatePeriod = channel.UpdatePeriod
+	out.UpdateFrequency = channel.UpdateFrequency
 	if channel.MinsToLive != 0 {
 		titleCaser := cases.Title(language.English)
 		sort.Ints(channel.SkipHours)
@@ -143,6 +146,9 @@ type rss2_0Channel struct {
 	MinsToLive  int          `xml:"ttl"`
 	SkipHours   []int        `xml:"skipHours>hour"`
 	SkipDays    []string     `xml:"skipDays>day"`
+
+	UpdatePeriod    string `xml:"http://purl.org/rss/1.0/modules/syndication/ updatePeriod"`
+	UpdateFrequency int    `xml:"http://purl.org/rss/1.0/modules/syndication/ updateFrequency"`
 }
 
 type rss2_0Link struct {
@@ -0,0 +1,29 @@
+package rss
+
+import (
+	"strings"
+	"time"
+)
+
+// updatePeriods maps the values of sy:updatePeriod to their duration.
+var updatePeriods = map[string]time.Duration{
+	"hourly":  time.Hour,
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+	"yearly":  365 * 24 * time.Hour,
+}
+
+// UpdateInterval returns the time between updates of the feed, as advertised
+// with sy:updatePeriod and sy:updateFrequency. It returns 0 when the feed
+// doesn't advertise an interval.
+func (f *Feed) UpdateInterval() time.Duration {
+	period, ok := updatePeriods[strings.ToLower(strings.TrimSpace(f.UpdatePeriod))]
+	if !ok {
+		return 0
+	}
+	if f.UpdateFrequency <= 1 {
+		return period
+	}
+	return period / time.Duration(f.UpdateFrequency)
+}
@@ -0,0 +1,60 @@
+package rss
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseUpdateHints(t *testing.T) {
+	tests := map[string]struct {
+		ttl      time.Duration
+		interval time.Duration
+	}{
+		"rss_2.0_ttl":         {ttl: time.Hour},
+		"rss_1.0_syndication": {interval: 6 * time.Hour},
+		"rss_2.0":             {ttl: 30 * time.Hour},
+	}
+
+	for test, want := range tests {
+		name := filepath.Join("testdata", test)
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Reading %s: %v", name, err)
+		}
+
+		feed, err := Parse(data)
+		if err != nil {
+			t.Fatalf("Parsing %s: %v", name, err)
+		}
+
+		if feed.TTL != want.ttl {
+			t.Errorf("%s: TTL got %v, want %v", name, feed.TTL, want.ttl)
+		}
+		if got := feed.UpdateInterval(); got != want.interval {
+			t.Errorf("%s: UpdateInterval got %v, want %v", name, got, want.interval)
+		}
+	}
+}
+
+func TestFeedUpdateInterval(t *testing.T) {
+	tests := []struct {
+		period    string
+		frequency int
+		want      time.Duration
+	}{
+		{"hourly", 0, time.Hour},
+		{"Weekly", 1, 7 * 24 * time.Hour},
+		{"daily", 2, 12 * time.Hour},
+		{"sometimes", 2, 0},
+		{"", 0, 0},
+	}
+
+	for _, test := range tests {
+		feed := Feed{UpdatePeriod: test.period, UpdateFrequency: test.frequency}
+		if got := feed.UpdateInterval(); got != test.want {
+			t.Errorf("%q/%d: got %v, want %v", test.period, test.frequency, got, test.want)
+		}
+	}
+}
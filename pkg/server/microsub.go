@@ -24,10 +24,13 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
 
 	"github.com/pstuifzand/ekster/pkg/microsub"
 	"github.com/pstuifzand/ekster/pkg/sse"
@@ -65,6 +68,23 @@ func NewMicrosubHandler(backend microsub.Microsub) (http.Handler, *sse.Broker) {
 	return &microsubHandler{backend, broker}, broker
 }
 
+// entryValues returns the entries from the "entry", "entry[]" or "entry[N]" values
+func entryValues(values url.Values) []string {
+	if uids, e := values["entry"]; e {
+		return uids
+	}
+	if uids, e := values["entry[]"]; e {
+		return uids
+	}
+	uids := []string{}
+	for k, v := range values {
+		if entryRegex.MatchString(k) {
+			uids = append(uids, v...)
+		}
+	}
+	return uids
+}
+
 // Methods required by http.Handler
 func (h *microsubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
@@ -95,7 +115,19 @@ func (h *microsubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				"channels": channels,
 			})
 		} else if action == "timeline" {
-			timeline, err := h.backend.TimelineGet(r.Context(), values.Get("before"), values.Get("after"), values.Get("channel"))
+			filter := microsub.TimelineFilter{
+				Unread: values.Get("is_read") == "false",
+				Source: values.Get("source"),
+			}
+			if limit := values.Get("limit"); limit != "" {
+				n, err := strconv.Atoi(limit)
+				if err != nil || n < 0 {
+					http.Error(w, fmt.Sprintf("limit %q is not a valid number", limit), http.StatusBadRequest)
+					return
+				}
+				filter.Limit = n
+			}
+			timeline, err := h.backend.TimelineGet(r.Context(), values.Get("before"), values.Get("after"), values.Get("channel"), filter)
 			if err != nil {
 				log.Println(err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -121,6 +153,27 @@ func (h *microsubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			respondJSON(w, map[string][]microsub.Feed{
 				"items": following,
 			})
+		} else if action == "mute" {
+			channel := values.Get("channel")
+			muted, err := h.backend.MuteGetList(r.Context(), channel)
+			if err != nil {
+				log.Println(err)
+				http.Error(w, err.Error(), errorStatus(err))
+				return
+			}
+			respondJSON(w, map[string][]microsub.Card{
+				"items": muted,
+			})
+		} else if action == "block" {
+			blocked, err := h.backend.BlockGetList(r.Context())
+			if err != nil {
+				log.Println(err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			respondJSON(w, map[string][]microsub.Card{
+				"items": blocked,
+			})
 		} else if action == "events" {
 			events, err := h.backend.Events(r.Context())
 			if err != nil {
@@ -172,6 +225,21 @@ func (h *microsubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			if method == "order" {
+				uids, e := values["channels[]"]
+				if !e {
+					uids = values["channels"]
+				}
+				err := h.backend.ChannelsOrder(r.Context(), uids)
+				if err != nil {
+					log.Println(err)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				respondJSON(w, []string{})
+				return
+			}
+
 			if uid == "" {
 				channel, err := h.backend.ChannelsCreate(r.Context(), name)
 				if err != nil {
@@ -208,6 +276,40 @@ func (h *microsubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			respondJSON(w, []string{})
+		} else if action == "mute" {
+			uid := values.Get("channel")
+			url := values.Get("url")
+			err := h.backend.Mute(r.Context(), uid, url)
+			if err != nil {
+				http.Error(w, err.Error(), errorStatus(err))
+				return
+			}
+			respondJSON(w, []string{})
+		} else if action == "unmute" {
+			uid := values.Get("channel")
+			url := values.Get("url")
+			err := h.backend.Unmute(r.Context(), uid, url)
+			if err != nil {
+				http.Error(w, err.Error(), errorStatus(err))
+				return
+			}
+			respondJSON(w, []string{})
+		} else if action == "block" {
+			url := values.Get("url")
+			err := h.backend.Block(r.Context(), url)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			respondJSON(w, []string{})
+		} else if action == "unblock" {
+			url := values.Get("url")
+			err := h.backend.Unblock(r.Context(), url)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			respondJSON(w, []string{})
 		} else if action == "preview" {
 			timeline, err := h.backend.PreviewURL(r.Context(), values.Get("url"))
 			if err != nil {
@@ -247,33 +349,64 @@ func (h *microsubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		} else if action == "timeline" || r.PostForm.Get("action") == "timeline" {
 			method := values.Get("method")
+			channel := values.Get("channel")
+			entries := entryValues(values)
 
 			if method == "mark_read" || r.PostForm.Get("method") == "mark_read" {
-				values = r.Form
-				channel := values.Get("channel")
-				var markAsRead []string
-				if uids, e := values["entry"]; e {
-					markAsRead = uids
-				} else if uids, e := values["entry[]"]; e {
-					markAsRead = uids
+				if lastReadEntry := values.Get("last_read_entry"); lastReadEntry != "" {
+					err := h.backend.MarkReadUntil(r.Context(), channel, lastReadEntry)
+					if err != nil {
+						http.Error(w, err.Error(), errorStatus(err))
+						return
+					}
+				} else if len(entries) > 0 {
+					err := h.backend.MarkRead(r.Context(), channel, entries)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+				} else {
+					log.Println("No uids specified for mark read")
+				}
+			} else if method == "mark_unread" {
+				if len(entries) > 0 {
+					err := h.backend.MarkUnread(r.Context(), channel, entries)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
 				} else {
-					uids := []string{}
-					for k, v := range values {
-						if entryRegex.MatchString(k) {
-							uids = append(uids, v...)
-						}
+					log.Println("No uids specified for mark unread")
+				}
+			} else if method == "remove" {
+				if len(entries) > 0 {
+					err := h.backend.RemoveItems(r.Context(), channel, entries)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
 					}
-					markAsRead = uids
+				} else {
+					log.Println("No uids specified for remove")
 				}
-
-				if len(markAsRead) > 0 {
-					err := h.backend.MarkRead(r.Context(), channel, markAsRead)
+			} else if method == "star" {
+				if len(entries) > 0 {
+					err := h.backend.StarItems(r.Context(), channel, entries)
 					if err != nil {
 						http.Error(w, err.Error(), http.StatusInternalServerError)
 						return
 					}
 				} else {
-					log.Println("No uids specified for mark read")
+					log.Println("No uids specified for star")
+				}
+			} else if method == "unstar" {
+				if len(entries) > 0 {
+					err := h.backend.UnstarItems(r.Context(), channel, entries)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+				} else {
+					log.Println("No uids specified for unstar")
 				}
 			} else {
 				http.Error(w, fmt.Sprintf("unknown method in timeline %s\n", method), http.StatusInternalServerError)
@@ -287,3 +420,14 @@ func (h *microsubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// errorStatus returns the HTTP status code for an error from the backend
+func errorStatus(err error) int {
+	if errors.Is(err, microsub.ErrChannelNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, microsub.ErrEntryNotFound) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
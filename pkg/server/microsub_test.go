@@ -20,6 +20,7 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -103,11 +104,19 @@ func TestServer_ChannelsUpdate(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestServer_ChannelsOrder(t *testing.T) {
+	server, c := createServerClient()
+	defer server.Close()
+	ctx := context.Background()
+	err := c.ChannelsOrder(ctx, []string{"0000", "0001"})
+	assert.NoError(t, err)
+}
+
 func TestServer_TimelineGet(t *testing.T) {
 	server, c := createServerClient()
 	defer server.Close()
 	ctx := context.Background()
-	timeline, err := c.TimelineGet(ctx, "", "", "0001")
+	timeline, err := c.TimelineGet(ctx, "", "", "0001", microsub.TimelineFilter{})
 	if assert.NoError(t, err) {
 		assert.Equal(t, 0, len(timeline.Items))
 	}
@@ -178,6 +187,98 @@ func TestServer_MarkRead(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestServer_MarkReadUntil(t *testing.T) {
+	server, c := createServerClient()
+	defer server.Close()
+	ctx := context.Background()
+	err := c.MarkReadUntil(ctx, "0001", "test")
+	assert.NoError(t, err)
+}
+
+func TestServer_MarkUnread(t *testing.T) {
+	server, c := createServerClient()
+	defer server.Close()
+	ctx := context.Background()
+	err := c.MarkUnread(ctx, "0001", []string{"test"})
+	assert.NoError(t, err)
+}
+
+func TestServer_RemoveItems(t *testing.T) {
+	server, c := createServerClient()
+	defer server.Close()
+	ctx := context.Background()
+	err := c.RemoveItems(ctx, "0001", []string{"test"})
+	assert.NoError(t, err)
+}
+
+func TestServer_StarItems(t *testing.T) {
+	server, c := createServerClient()
+	defer server.Close()
+	ctx := context.Background()
+	err := c.StarItems(ctx, "0001", []string{"test"})
+	assert.NoError(t, err)
+}
+
+func TestServer_UnstarItems(t *testing.T) {
+	server, c := createServerClient()
+	defer server.Close()
+	ctx := context.Background()
+	err := c.UnstarItems(ctx, "0001", []string{"test"})
+	assert.NoError(t, err)
+}
+
+func TestServer_MuteGetList(t *testing.T) {
+	server, c := createServerClient()
+	defer server.Close()
+	ctx := context.Background()
+	muted, err := c.MuteGetList(ctx, "0001")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, len(muted))
+	}
+}
+
+func TestServer_Mute(t *testing.T) {
+	server, c := createServerClient()
+	defer server.Close()
+	ctx := context.Background()
+	err := c.Mute(ctx, "0001", "https://example.com/")
+	assert.NoError(t, err)
+}
+
+func TestServer_Unmute(t *testing.T) {
+	server, c := createServerClient()
+	defer server.Close()
+	ctx := context.Background()
+	err := c.Unmute(ctx, "0001", "https://example.com/")
+	assert.NoError(t, err)
+}
+
+func TestServer_BlockGetList(t *testing.T) {
+	server, c := createServerClient()
+	defer server.Close()
+	ctx := context.Background()
+	blocked, err := c.BlockGetList(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, len(blocked))
+	}
+}
+
+func TestServer_Block(t *testing.T) {
+	server, c := createServerClient()
+	defer server.Close()
+	ctx := context.Background()
+	err := c.Block(ctx, "https://example.com/")
+	assert.NoError(t, err)
+}
+
+func TestServer_Unblock(t *testing.T) {
+	server, c := createServerClient()
+	defer server.Close()
+	ctx := context.Background()
+	err := c.Unblock(ctx, "https://example.com/")
+	assert.NoError(t, err)
+}
+
 func TestServer_GetUnknownAction(t *testing.T) {
 	server, c := createServerClient()
 	defer server.Close()
@@ -206,3 +307,62 @@ func TestServer_PostUnknownAction(t *testing.T) {
 		assert.Equal(t, 400, resp.StatusCode)
 	}
 }
+
+// missingChannelBackend is a backend without any channels or entries
+type missingChannelBackend struct {
+	NullBackend
+}
+
+func (b *missingChannelBackend) MuteGetList(ctx context.Context, channel string) ([]microsub.Card, error) {
+	return nil, fmt.Errorf("channel %q: %w", channel, microsub.ErrChannelNotFound)
+}
+
+func (b *missingChannelBackend) Mute(ctx context.Context, channel, url string) error {
+	return fmt.Errorf("channel %q: %w", channel, microsub.ErrChannelNotFound)
+}
+
+func (b *missingChannelBackend) Unmute(ctx context.Context, channel, url string) error {
+	return fmt.Errorf("channel %q: %w", channel, microsub.ErrChannelNotFound)
+}
+
+func (b *missingChannelBackend) MarkReadUntil(ctx context.Context, channel, lastReadEntry string) error {
+	return fmt.Errorf("entry %q: %w", lastReadEntry, microsub.ErrEntryNotFound)
+}
+
+func TestServer_MuteMissingChannel(t *testing.T) {
+	handler, _ := NewMicrosubHandler(&missingChannelBackend{})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/microsub?action=mute&channel=missing")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+
+	for _, action := range []string{"mute", "unmute"} {
+		resp, err := http.PostForm(server.URL+"/microsub", url.Values{
+			"action":  {action},
+			"channel": {"missing"},
+			"url":     {"https://example.com/"},
+		})
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode, action)
+		}
+	}
+}
+
+func TestServer_MarkReadUntilMissingEntry(t *testing.T) {
+	handler, _ := NewMicrosubHandler(&missingChannelBackend{})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.PostForm(server.URL+"/microsub", url.Values{
+		"action":          {"timeline"},
+		"method":          {"mark_read"},
+		"channel":         {"0001"},
+		"last_read_entry": {"missing"},
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
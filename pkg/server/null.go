@@ -58,8 +58,13 @@ func (b *NullBackend) ChannelsDelete(ctx context.Context, uid string) error {
 	return nil
 }
 
+// ChannelsOrder orders no channels
+func (b *NullBackend) ChannelsOrder(ctx context.Context, uids []string) error {
+	return nil
+}
+
 // TimelineGet gets no timeline
-func (b *NullBackend) TimelineGet(ctx context.Context, before, after, channel string) (microsub.Timeline, error) {
+func (b *NullBackend) TimelineGet(ctx context.Context, before, after, channel string, filter microsub.TimelineFilter) (microsub.Timeline, error) {
 	return microsub.Timeline{
 		Paging: microsub.Pagination{},
 		Items:  []microsub.Item{},
@@ -103,11 +108,66 @@ func (b *NullBackend) PreviewURL(ctx context.Context, url string) (microsub.Time
 	}, nil
 }
 
+// MuteGetList returns an empty list of muted users
+func (b *NullBackend) MuteGetList(ctx context.Context, channel string) ([]microsub.Card, error) {
+	return []microsub.Card{}, nil
+}
+
+// Mute mutes no users
+func (b *NullBackend) Mute(ctx context.Context, channel, url string) error {
+	return nil
+}
+
+// Unmute unmutes no users
+func (b *NullBackend) Unmute(ctx context.Context, channel, url string) error {
+	return nil
+}
+
+// BlockGetList returns an empty list of blocked users
+func (b *NullBackend) BlockGetList(ctx context.Context) ([]microsub.Card, error) {
+	return []microsub.Card{}, nil
+}
+
+// Block blocks no users
+func (b *NullBackend) Block(ctx context.Context, url string) error {
+	return nil
+}
+
+// Unblock unblocks no users
+func (b *NullBackend) Unblock(ctx context.Context, url string) error {
+	return nil
+}
+
 // MarkRead marks no items as read
 func (b *NullBackend) MarkRead(ctx context.Context, channel string, uids []string) error {
 	return nil
 }
 
+// MarkReadUntil marks no items as read
+func (b *NullBackend) MarkReadUntil(ctx context.Context, channel, lastReadEntry string) error {
+	return nil
+}
+
+// MarkUnread marks no items as unread
+func (b *NullBackend) MarkUnread(ctx context.Context, channel string, uids []string) error {
+	return nil
+}
+
+// RemoveItems removes no items
+func (b *NullBackend) RemoveItems(ctx context.Context, channel string, uids []string) error {
+	return nil
+}
+
+// StarItems stars no items
+func (b *NullBackend) StarItems(ctx context.Context, channel string, uids []string) error {
+	return nil
+}
+
+// UnstarItems unstars no items
+func (b *NullBackend) UnstarItems(ctx context.Context, channel string, uids []string) error {
+	return nil
+}
+
 // Events returns a closed channel.
 func (b *NullBackend) Events(ctx context.Context) (chan sse.Message, error) {
 	ch := make(chan sse.Message)
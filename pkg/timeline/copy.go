@@ -0,0 +1,150 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pstuifzand/ekster/pkg/microsub"
+)
+
+// Copy adds all items of src to dst and keeps their read and starred state.
+// It returns the number of items that were added to dst. Items that dst
+// already contains get the read and starred state of src. When src implements
+// ReadLister, the read items are copied too. When src implements
+// RemovedLister, the removed items are also removed in dst, so they are not
+// added again. The starred state is only kept when dst implements Starrer, and
+// starred items of dst are only unstarred when src implements Starrer.
+func Copy(ctx context.Context, dst, src Backend) (int, error) {
+	_, srcStars := src.(Starrer)
+
+	count, err := copyItems(ctx, dst, src, srcStars)
+	if err != nil {
+		return count, err
+	}
+
+	if lister, ok := src.(ReadLister); ok {
+		read, err := lister.ReadItems(ctx)
+		if err != nil {
+			return count, fmt.Errorf("while reading read items: %w", err)
+		}
+		n, err := addItemsTo(ctx, dst, read, srcStars)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+
+	lister, ok := src.(RemovedLister)
+	if !ok {
+		return count, nil
+	}
+	removed, err := lister.RemovedItems(ctx)
+	if err != nil {
+		return count, fmt.Errorf("while reading removed items: %w", err)
+	}
+	var uids []string
+	for _, item := range removed {
+		if _, err := dst.AddItem(ctx, item); err != nil {
+			return count, fmt.Errorf("while adding item %s: %w", item.ID, err)
+		}
+		uids = append(uids, item.ID)
+	}
+	if len(uids) > 0 {
+		if err := dst.Remove(ctx, uids); err != nil {
+			return count, fmt.Errorf("while removing items: %w", err)
+		}
+	}
+	return count, nil
+}
+
+func copyItems(ctx context.Context, dst, src Backend, srcStars bool) (int, error) {
+	count := 0
+	after := ""
+	for {
+		page, err := src.Items(ctx, "", after, microsub.TimelineFilter{Limit: MaxLimit})
+		if err != nil {
+			return count, fmt.Errorf("while reading items: %w", err)
+		}
+
+		n, err := addItemsTo(ctx, dst, page.Items, srcStars)
+		count += n
+		if err != nil {
+			return count, err
+		}
+
+		if page.Paging.After == "" || len(page.Items) == 0 {
+			return count, nil
+		}
+		after = page.Paging.After
+	}
+}
+
+// addItemsTo adds the items to dst with their read and starred state, and
+// returns the number of items that were added. Items that dst already
+// contains get the read state of the item, and the starred state when
+// srcStars is true.
+func addItemsTo(ctx context.Context, dst Backend, items []microsub.Item, srcStars bool) (int, error) {
+	starrer, canStar := dst.(Starrer)
+
+	count := 0
+	var read, unread, starred, unstarred []string
+	for _, item := range items {
+		added, err := dst.AddItem(ctx, item)
+		if err != nil {
+			return count, fmt.Errorf("while adding item %s: %w", item.ID, err)
+		}
+		if added {
+			count++
+		}
+		if item.Read {
+			read = append(read, item.ID)
+		} else if !added {
+			unread = append(unread, item.ID)
+		}
+		if item.Starred {
+			starred = append(starred, item.ID)
+		} else if !added && srcStars {
+			unstarred = append(unstarred, item.ID)
+		}
+	}
+
+	if len(read) > 0 {
+		if err := dst.MarkRead(ctx, read); err != nil {
+			return count, fmt.Errorf("while marking items read: %w", err)
+		}
+	}
+	if len(unread) > 0 {
+		if err := dst.MarkUnread(ctx, unread); err != nil {
+			return count, fmt.Errorf("while marking items unread: %w", err)
+		}
+	}
+	if canStar && len(starred) > 0 {
+		if err := starrer.Star(ctx, starred); err != nil {
+			return count, fmt.Errorf("while starring items: %w", err)
+		}
+	}
+	if canStar && len(unstarred) > 0 {
+		if err := starrer.Unstar(ctx, unstarred); err != nil {
+			return count, fmt.Errorf("while unstarring items: %w", err)
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,106 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package timeline
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes for Items
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// ErrInvalidCursor is returned when a paging cursor can't be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the position of an item in a timeline. Items are ordered by
+// published date and id, so items with the same published date keep a
+// stable order.
+type cursor struct {
+	Published time.Time
+	ID        int
+}
+
+// String returns the opaque representation of the cursor
+func (c cursor) String() string {
+	s := fmt.Sprintf("%s,%d", c.Published.UTC().Format(time.RFC3339Nano), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// parseCursor decodes a cursor that was created with cursor.String. For
+// compatibility with earlier versions a plain RFC3339 date is also accepted,
+// the id is then set to defaultID.
+func parseCursor(s string, defaultID int) (cursor, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return cursor{Published: t, ID: defaultID}, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
+	}
+
+	parts := strings.SplitN(string(b), ",", 2)
+	if len(parts) != 2 {
+		return cursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return cursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return cursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
+	}
+
+	return cursor{Published: t, ID: id}, nil
+}
+
+// parseBeforeCursor parses the cursor of the before parameter. A plain date
+// selects the items published after that date.
+func parseBeforeCursor(s string) (cursor, error) {
+	return parseCursor(s, math.MaxInt32)
+}
+
+// parseAfterCursor parses the cursor of the after parameter. A plain date
+// selects the items published before that date.
+func parseAfterCursor(s string) (cursor, error) {
+	return parseCursor(s, 0)
+}
+
+// pageSize returns the number of items in a page for the requested limit
+func pageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultLimit
+	}
+	if limit > MaxLimit {
+		return MaxLimit
+	}
+	return limit
+}
@@ -0,0 +1,65 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package timeline
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor_RoundTrip(t *testing.T) {
+	published := time.Date(2022, 3, 4, 10, 11, 12, 123456000, time.UTC)
+	c := cursor{Published: published, ID: 42}
+
+	parsed, err := parseAfterCursor(c.String())
+	if assert.NoError(t, err) {
+		assert.True(t, published.Equal(parsed.Published))
+		assert.Equal(t, 42, parsed.ID)
+	}
+}
+
+func TestCursor_PlainDate(t *testing.T) {
+	before, err := parseBeforeCursor("2022-03-04T10:11:12Z")
+	if assert.NoError(t, err) {
+		assert.Equal(t, math.MaxInt32, before.ID)
+	}
+
+	after, err := parseAfterCursor("2022-03-04T10:11:12Z")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, after.ID)
+	}
+}
+
+func TestCursor_Invalid(t *testing.T) {
+	for _, s := range []string{"not a cursor", "bm90IGEgY3Vyc29y", "MjAyMi0wMy0wNFQxMDoxMToxMlosYWJj"} {
+		_, err := parseAfterCursor(s)
+		assert.True(t, errors.Is(err, ErrInvalidCursor), s)
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	assert.Equal(t, DefaultLimit, pageSize(0))
+	assert.Equal(t, DefaultLimit, pageSize(-1))
+	assert.Equal(t, 5, pageSize(5))
+	assert.Equal(t, MaxLimit, pageSize(MaxLimit+1))
+}
@@ -0,0 +1,387 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package timeline
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pstuifzand/ekster/pkg/microsub"
+)
+
+func init() {
+	Register("memory", func(ctx context.Context, channel string, pool *redis.Pool, db *sql.DB) (Backend, error) {
+		return memoryTimelines.get(channel), nil
+	})
+}
+
+// memoryTimelines contains the timelines of type "memory", so the items of a
+// channel are kept between calls to Create
+var memoryTimelines = &memoryStore{timelines: make(map[string]*memoryTimeline)}
+
+type memoryStore struct {
+	lock      sync.Mutex
+	timelines map[string]*memoryTimeline
+}
+
+func (s *memoryStore) get(channel string) *memoryTimeline {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tl, ok := s.timelines[channel]
+	if !ok {
+		tl = newMemoryTimeline(channel)
+		s.timelines[channel] = tl
+	}
+	return tl
+}
+
+type memoryItem struct {
+	id        int
+	published time.Time
+	removed   bool
+	item      microsub.Item
+}
+
+func (mi *memoryItem) cursor() cursor {
+	return cursor{Published: mi.published, ID: mi.id}
+}
+
+// memoryTimeline keeps the items of a channel in memory. The items are sorted
+// from new to old.
+type memoryTimeline struct {
+	lock    sync.RWMutex
+	channel string
+	nextID  int
+	items   []*memoryItem
+	byUID   map[string]*memoryItem
+
+	// pruned contains the uids of the pruned and deleted items
+	pruned map[string]bool
+}
+
+// NewMemory creates an empty timeline that keeps its items in memory. Unlike
+// the timelines of type "memory", the items are not shared with other
+// timelines for the same channel.
+func NewMemory(channel string) Backend {
+	return newMemoryTimeline(channel)
+}
+
+func newMemoryTimeline(channel string) *memoryTimeline {
+	return &memoryTimeline{
+		channel: channel,
+		nextID:  1,
+		byUID:   make(map[string]*memoryItem),
+		pruned:  make(map[string]bool),
+	}
+}
+
+// Items returns a page of items
+func (timeline *memoryTimeline) Items(ctx context.Context, before, after string, filter microsub.TimelineFilter) (microsub.Timeline, error) {
+	timeline.lock.RLock()
+	defer timeline.lock.RUnlock()
+
+	var matches []*memoryItem
+	for _, mi := range timeline.items {
+		if mi.removed {
+			continue
+		}
+		if filter.Unread && mi.item.Read {
+			continue
+		}
+		if filter.Source != "" && (mi.item.Source == nil || mi.item.Source.ID != filter.Source) {
+			continue
+		}
+		matches = append(matches, mi)
+	}
+
+	limit := pageSize(filter.Limit)
+
+	// start and end are the bounds of the page in matches
+	start, end := 0, len(matches)
+	if before != "" {
+		c, err := parseBeforeCursor(before)
+		if err != nil {
+			return microsub.Timeline{}, err
+		}
+		end = sort.Search(len(matches), func(i int) bool {
+			return !cursorLess(c, matches[i].cursor())
+		})
+		if end > limit {
+			start = end - limit
+		}
+	} else {
+		if after != "" {
+			c, err := parseAfterCursor(after)
+			if err != nil {
+				return microsub.Timeline{}, err
+			}
+			start = sort.Search(len(matches), func(i int) bool {
+				return cursorLess(matches[i].cursor(), c)
+			})
+		}
+		if end-start > limit {
+			end = start + limit
+		}
+	}
+
+	tl := microsub.Timeline{Items: []microsub.Item{}}
+	for _, mi := range matches[start:end] {
+		tl.Items = append(tl.Items, mi.item)
+	}
+
+	if start < end {
+		if start > 0 {
+			tl.Paging.Before = matches[start].cursor().String()
+		}
+		if end < len(matches) {
+			tl.Paging.After = matches[end-1].cursor().String()
+		}
+	}
+
+	return tl, nil
+}
+
+// Count returns the number of unread items
+func (timeline *memoryTimeline) Count(ctx context.Context) (int, error) {
+	timeline.lock.RLock()
+	defer timeline.lock.RUnlock()
+
+	count := 0
+	for _, mi := range timeline.items {
+		if !mi.removed && !mi.item.Read {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// AddItem adds an item, returns false when the item was added before
+func (timeline *memoryTimeline) AddItem(ctx context.Context, item microsub.Item) (bool, error) {
+	published, err := parsePublished(item.Published)
+	if err != nil {
+		return false, fmt.Errorf("while adding item: %w", err)
+	}
+
+	timeline.lock.Lock()
+	defer timeline.lock.Unlock()
+
+	if item.ID == "" {
+		h := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", timeline.channel, time.Now().UnixNano())))
+		item.ID = hex.EncodeToString(h[:])
+	}
+
+	if _, e := timeline.byUID[item.ID]; e {
+		return false, nil
+	}
+
+	if timeline.pruned[item.ID] {
+		return false, nil
+	}
+
+	mi := &memoryItem{id: timeline.nextID, published: published, item: item}
+	timeline.nextID++
+
+	i := sort.Search(len(timeline.items), func(i int) bool {
+		return cursorLess(timeline.items[i].cursor(), mi.cursor())
+	})
+	timeline.items = append(timeline.items, nil)
+	copy(timeline.items[i+1:], timeline.items[i:])
+	timeline.items[i] = mi
+	timeline.byUID[item.ID] = mi
+
+	return true, nil
+}
+
+// MarkRead marks the items as read
+func (timeline *memoryTimeline) MarkRead(ctx context.Context, uids []string) error {
+	timeline.setRead(uids, true)
+	return nil
+}
+
+// MarkReadUntil marks the item with uid and all items before it in the
+// timeline order as read
+func (timeline *memoryTimeline) MarkReadUntil(ctx context.Context, uid string) error {
+	timeline.lock.Lock()
+	defer timeline.lock.Unlock()
+
+	last, ok := timeline.byUID[uid]
+	if !ok {
+		return ErrItemNotFound
+	}
+	for _, mi := range timeline.items {
+		if !cursorLess(last.cursor(), mi.cursor()) {
+			mi.item.Read = true
+		}
+	}
+	return nil
+}
+
+// MarkUnread marks the items as unread
+func (timeline *memoryTimeline) MarkUnread(ctx context.Context, uids []string) error {
+	timeline.setRead(uids, false)
+	return nil
+}
+
+func (timeline *memoryTimeline) setRead(uids []string, read bool) {
+	timeline.lock.Lock()
+	defer timeline.lock.Unlock()
+
+	for _, uid := range uids {
+		if mi, ok := timeline.byUID[uid]; ok {
+			mi.item.Read = read
+		}
+	}
+}
+
+// Star marks the items as starred
+func (timeline *memoryTimeline) Star(ctx context.Context, uids []string) error {
+	timeline.setStarred(uids, true)
+	return nil
+}
+
+// Unstar removes the star from the items
+func (timeline *memoryTimeline) Unstar(ctx context.Context, uids []string) error {
+	timeline.setStarred(uids, false)
+	return nil
+}
+
+func (timeline *memoryTimeline) setStarred(uids []string, starred bool) {
+	timeline.lock.Lock()
+	defer timeline.lock.Unlock()
+
+	for _, uid := range uids {
+		if mi, ok := timeline.byUID[uid]; ok {
+			mi.item.Starred = starred
+		}
+	}
+}
+
+// Remove hides the items from the channel. The items are remembered, so they
+// are not added again.
+func (timeline *memoryTimeline) Remove(ctx context.Context, uids []string) error {
+	timeline.lock.Lock()
+	defer timeline.lock.Unlock()
+
+	for _, uid := range uids {
+		if mi, ok := timeline.byUID[uid]; ok {
+			mi.removed = true
+		}
+	}
+	return nil
+}
+
+// RemovedItems returns the removed items
+func (timeline *memoryTimeline) RemovedItems(ctx context.Context) ([]microsub.Item, error) {
+	timeline.lock.RLock()
+	defer timeline.lock.RUnlock()
+
+	var items []microsub.Item
+	for _, mi := range timeline.items {
+		if mi.removed {
+			items = append(items, mi.item)
+		}
+	}
+	return items, nil
+}
+
+// ItemsByUID returns the items with the uids
+func (timeline *memoryTimeline) ItemsByUID(ctx context.Context, uids []string) ([]microsub.Item, error) {
+	timeline.lock.RLock()
+	defer timeline.lock.RUnlock()
+
+	var items []microsub.Item
+	for _, uid := range uids {
+		mi, ok := timeline.byUID[uid]
+		if !ok || mi.removed {
+			return nil, ErrItemNotFound
+		}
+		items = append(items, mi.item)
+	}
+	return items, nil
+}
+
+// Prune deletes the items selected by opts
+func (timeline *memoryTimeline) Prune(ctx context.Context, opts PruneOptions) ([]string, error) {
+	timeline.lock.Lock()
+	defer timeline.lock.Unlock()
+
+	var uids []string
+	var kept []*memoryItem
+	for n, mi := range timeline.items {
+		if !opts.selects(n, mi.published, mi.item.Read || mi.removed, mi.item.Starred) {
+			kept = append(kept, mi)
+			continue
+		}
+		uids = append(uids, mi.item.ID)
+		delete(timeline.byUID, mi.item.ID)
+		timeline.pruned[mi.item.ID] = true
+	}
+	timeline.items = kept
+
+	return uids, nil
+}
+
+// Delete deletes the items with the uids
+func (timeline *memoryTimeline) Delete(ctx context.Context, uids []string) error {
+	timeline.lock.Lock()
+	defer timeline.lock.Unlock()
+
+	deleted := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		deleted[uid] = true
+	}
+
+	var kept []*memoryItem
+	for _, mi := range timeline.items {
+		if !deleted[mi.item.ID] {
+			kept = append(kept, mi)
+			continue
+		}
+		delete(timeline.byUID, mi.item.ID)
+		timeline.pruned[mi.item.ID] = true
+	}
+	timeline.items = kept
+
+	return nil
+}
+
+// Clear deletes all items. The pruned and deleted items are remembered.
+func (timeline *memoryTimeline) Clear(ctx context.Context) error {
+	timeline.lock.Lock()
+	defer timeline.lock.Unlock()
+
+	timeline.items = nil
+	timeline.byUID = make(map[string]*memoryItem)
+	return nil
+}
+
+// cursorLess returns true when the item at a is older than the item at b
+func cursorLess(a, b cursor) bool {
+	if a.Published.Equal(b.Published) {
+		return a.ID < b.ID
+	}
+	return a.Published.Before(b.Published)
+}
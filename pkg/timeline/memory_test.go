@@ -0,0 +1,382 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pstuifzand/ekster/pkg/microsub"
+	"github.com/stretchr/testify/assert"
+)
+
+// addItems adds n items, item i is published i minutes after start. Items 0
+// and 1 have the same published date.
+func addItems(t *testing.T, tl Backend, n int) {
+	start := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		published := start.Add(time.Duration(i) * time.Minute)
+		if i == 0 {
+			published = start.Add(time.Minute)
+		}
+		added, err := tl.AddItem(context.Background(), microsub.Item{
+			Type:      "entry",
+			ID:        fmt.Sprintf("item-%d", i),
+			Published: published.Format(time.RFC3339),
+			Source:    &microsub.Source{ID: fmt.Sprintf("%d", i%2)},
+		})
+		assert.NoError(t, err)
+		assert.True(t, added)
+	}
+}
+
+func itemIDs(items []microsub.Item) []string {
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestMemory_Items(t *testing.T) {
+	ctx := context.Background()
+	tl := NewMemory("test")
+	addItems(t, tl, 5)
+
+	filter := microsub.TimelineFilter{Limit: 2}
+
+	page, err := tl.Items(ctx, "", "", filter)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"item-4", "item-3"}, itemIDs(page.Items))
+	assert.Empty(t, page.Paging.Before)
+	assert.NotEmpty(t, page.Paging.After)
+
+	page, err = tl.Items(ctx, "", page.Paging.After, filter)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"item-2", "item-1"}, itemIDs(page.Items))
+	assert.NotEmpty(t, page.Paging.Before)
+	assert.NotEmpty(t, page.Paging.After)
+
+	// item-0 has the same published date as item-1
+	last, err := tl.Items(ctx, "", page.Paging.After, filter)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"item-0"}, itemIDs(last.Items))
+	assert.Empty(t, last.Paging.After)
+
+	page, err = tl.Items(ctx, last.Paging.Before, "", filter)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"item-2", "item-1"}, itemIDs(page.Items))
+}
+
+func TestMemory_Filter(t *testing.T) {
+	ctx := context.Background()
+	tl := NewMemory("test")
+	addItems(t, tl, 5)
+
+	assert.NoError(t, tl.MarkRead(ctx, []string{"item-4"}))
+
+	page, err := tl.Items(ctx, "", "", microsub.TimelineFilter{Unread: true, Source: "0"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"item-2", "item-0"}, itemIDs(page.Items))
+}
+
+func TestMemory_ReadState(t *testing.T) {
+	ctx := context.Background()
+	tl := NewMemory("test")
+	addItems(t, tl, 5)
+
+	count, err := tl.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, count)
+
+	assert.NoError(t, tl.MarkReadUntil(ctx, "item-2"))
+	count, _ = tl.Count(ctx)
+	assert.Equal(t, 2, count)
+
+	assert.Equal(t, ErrItemNotFound, tl.MarkReadUntil(ctx, "missing"))
+
+	assert.NoError(t, tl.MarkUnread(ctx, []string{"item-0"}))
+	count, _ = tl.Count(ctx)
+	assert.Equal(t, 3, count)
+
+	items, err := tl.ItemsByUID(ctx, []string{"item-0", "item-1"})
+	assert.NoError(t, err)
+	if assert.Len(t, items, 2) {
+		assert.False(t, items[0].Read)
+		assert.True(t, items[1].Read)
+	}
+}
+
+func TestMemory_MarkReadUntil_SamePublished(t *testing.T) {
+	ctx := context.Background()
+	tl := NewMemory("test")
+	addItems(t, tl, 3)
+
+	// item-0 and item-1 have the same published date, item-1 comes after
+	// item-0 in the timeline
+	assert.NoError(t, tl.MarkReadUntil(ctx, "item-0"))
+
+	items, err := tl.ItemsByUID(ctx, []string{"item-0", "item-1", "item-2"})
+	if assert.NoError(t, err) {
+		assert.True(t, items[0].Read)
+		assert.False(t, items[1].Read)
+		assert.False(t, items[2].Read)
+	}
+}
+
+func TestMemory_Remove(t *testing.T) {
+	ctx := context.Background()
+	tl := NewMemory("test")
+	addItems(t, tl, 3)
+
+	assert.NoError(t, tl.Remove(ctx, []string{"item-1"}))
+
+	_, err := tl.ItemsByUID(ctx, []string{"item-1"})
+	assert.Equal(t, ErrItemNotFound, err)
+
+	added, err := tl.AddItem(ctx, microsub.Item{ID: "item-1", Published: "2022-01-01T12:00:00Z"})
+	assert.NoError(t, err)
+	assert.False(t, added)
+
+	count, _ := tl.Count(ctx)
+	assert.Equal(t, 2, count)
+}
+
+func TestCreate_Memory(t *testing.T) {
+	ctx := context.Background()
+	tl := Create(ctx, "create-test", "memory", nil, nil)
+	if assert.NotNil(t, tl) {
+		addItems(t, tl, 1)
+	}
+
+	count, err := Create(ctx, "create-test", "memory", nil, nil).Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	assert.Nil(t, Create(ctx, "create-test", "unknown", nil, nil))
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemory("src")
+	addItems(t, src, 250)
+	assert.NoError(t, src.MarkRead(ctx, []string{"item-3"}))
+	assert.NoError(t, src.Remove(ctx, []string{"item-4"}))
+	assert.NoError(t, src.(Starrer).Star(ctx, []string{"item-5"}))
+
+	dst := NewMemory("dst")
+	addItems(t, dst, 1)
+
+	n, err := Copy(ctx, dst, src)
+	assert.NoError(t, err)
+	assert.Equal(t, 248, n)
+
+	count, _ := dst.Count(ctx)
+	assert.Equal(t, 248, count)
+
+	items, err := dst.ItemsByUID(ctx, []string{"item-3"})
+	if assert.NoError(t, err) {
+		assert.True(t, items[0].Read)
+	}
+	_, err = dst.ItemsByUID(ctx, []string{"item-4"})
+	assert.Equal(t, ErrItemNotFound, err)
+
+	// removed items are not added again
+	added, err := dst.AddItem(ctx, microsub.Item{ID: "item-4", Published: "2022-01-01T12:04:00Z"})
+	assert.NoError(t, err)
+	assert.False(t, added)
+
+	items, err = dst.ItemsByUID(ctx, []string{"item-5"})
+	if assert.NoError(t, err) {
+		assert.True(t, items[0].Starred)
+	}
+}
+
+func TestCopy_existingItems(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemory("src")
+	addItems(t, src, 3)
+	assert.NoError(t, src.MarkRead(ctx, []string{"item-1"}))
+	assert.NoError(t, src.(Starrer).Star(ctx, []string{"item-2"}))
+
+	dst := NewMemory("dst")
+	addItems(t, dst, 3)
+	assert.NoError(t, dst.MarkRead(ctx, []string{"item-0"}))
+	assert.NoError(t, dst.(Starrer).Star(ctx, []string{"item-0"}))
+
+	n, err := Copy(ctx, dst, src)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// the items that dst already contains get the state of src
+	items, err := dst.ItemsByUID(ctx, []string{"item-0", "item-1", "item-2"})
+	if assert.NoError(t, err) {
+		assert.False(t, items[0].Read)
+		assert.False(t, items[0].Starred)
+		assert.True(t, items[1].Read)
+		assert.True(t, items[2].Starred)
+	}
+}
+
+func TestMemory_Clear(t *testing.T) {
+	ctx := context.Background()
+	tl := NewMemory("test")
+	addItems(t, tl, 3)
+	_, err := tl.(Pruner).Prune(ctx, PruneOptions{MaxItems: 2})
+	assert.NoError(t, err)
+
+	assert.NoError(t, tl.(Clearer).Clear(ctx))
+	count, _ := tl.Count(ctx)
+	assert.Equal(t, 0, count)
+
+	// cleared items can be added again, pruned items can't
+	added, err := tl.AddItem(ctx, microsub.Item{ID: "item-2", Published: "2022-01-01T12:02:00Z"})
+	assert.NoError(t, err)
+	assert.True(t, added)
+	added, err = tl.AddItem(ctx, microsub.Item{ID: "item-0", Published: "2022-01-01T12:00:00Z"})
+	assert.NoError(t, err)
+	assert.False(t, added)
+}
+
+// unreadOnlyTimeline only returns unread items from Items, like the
+// sorted-set timeline
+type unreadOnlyTimeline struct {
+	Backend
+}
+
+func (tl unreadOnlyTimeline) Items(ctx context.Context, before, after string, filter microsub.TimelineFilter) (microsub.Timeline, error) {
+	filter.Unread = true
+	return tl.Backend.Items(ctx, before, after, filter)
+}
+
+func (tl unreadOnlyTimeline) ReadItems(ctx context.Context) ([]microsub.Item, error) {
+	var items []microsub.Item
+	after := ""
+	for {
+		page, err := tl.Backend.Items(ctx, "", after, microsub.TimelineFilter{Limit: MaxLimit})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			if item.Read {
+				items = append(items, item)
+			}
+		}
+		if page.Paging.After == "" {
+			return items, nil
+		}
+		after = page.Paging.After
+	}
+}
+
+func TestCopy_readLister(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemory("src")
+	addItems(t, src, 5)
+	assert.NoError(t, src.MarkRead(ctx, []string{"item-1", "item-3"}))
+
+	dst := NewMemory("dst")
+	n, err := Copy(ctx, dst, unreadOnlyTimeline{src})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	count, _ := dst.Count(ctx)
+	assert.Equal(t, 3, count)
+
+	items, err := dst.ItemsByUID(ctx, []string{"item-1", "item-2", "item-3"})
+	if assert.NoError(t, err) {
+		assert.True(t, items[0].Read)
+		assert.False(t, items[1].Read)
+		assert.True(t, items[2].Read)
+	}
+}
+
+func TestMemory_Prune(t *testing.T) {
+	ctx := context.Background()
+	tl := NewMemory("test")
+	addItems(t, tl, 5)
+	assert.NoError(t, tl.MarkRead(ctx, []string{"item-0", "item-1", "item-3"}))
+
+	uids, err := tl.(Pruner).Prune(ctx, PruneOptions{MaxItems: 2, KeepUnread: true})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"item-1", "item-0"}, uids)
+
+	page, _ := tl.Items(ctx, "", "", microsub.TimelineFilter{})
+	assert.Equal(t, []string{"item-4", "item-3", "item-2"}, itemIDs(page.Items))
+
+	// pruned items are not added again, older items that were not pruned are
+	added, err := tl.AddItem(ctx, microsub.Item{ID: "item-0", Published: "2022-01-01T12:01:00Z"})
+	assert.NoError(t, err)
+	assert.False(t, added)
+	added, err = tl.AddItem(ctx, microsub.Item{ID: "item-late", Published: "2022-01-01T11:00:00Z"})
+	assert.NoError(t, err)
+	assert.True(t, added)
+
+	uids, err = tl.(Pruner).Prune(ctx, PruneOptions{Before: time.Date(2022, 1, 1, 12, 4, 0, 0, time.UTC)})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"item-3", "item-2", "item-late"}, uids)
+
+	count, _ := tl.Count(ctx)
+	assert.Equal(t, 1, count)
+}
+
+func TestMemory_Delete(t *testing.T) {
+	ctx := context.Background()
+	tl := NewMemory("test")
+	addItems(t, tl, 3)
+
+	assert.NoError(t, tl.(Deleter).Delete(ctx, []string{"item-0", "item-2"}))
+
+	page, _ := tl.Items(ctx, "", "", microsub.TimelineFilter{})
+	assert.Equal(t, []string{"item-1"}, itemIDs(page.Items))
+	_, err := tl.ItemsByUID(ctx, []string{"item-0"})
+	assert.Equal(t, ErrItemNotFound, err)
+
+	// deleted items are not added again
+	added, err := tl.AddItem(ctx, microsub.Item{ID: "item-0", Published: "2022-01-01T12:00:00Z"})
+	assert.NoError(t, err)
+	assert.False(t, added)
+}
+
+func TestMemory_Star(t *testing.T) {
+	ctx := context.Background()
+	tl := NewMemory("test")
+	addItems(t, tl, 3)
+	assert.NoError(t, tl.MarkRead(ctx, []string{"item-0", "item-1", "item-2"}))
+
+	assert.NoError(t, tl.(Starrer).Star(ctx, []string{"item-0", "item-1"}))
+	assert.NoError(t, tl.(Starrer).Unstar(ctx, []string{"item-1"}))
+
+	items, err := tl.ItemsByUID(ctx, []string{"item-0", "item-1"})
+	if assert.NoError(t, err) {
+		assert.True(t, items[0].Starred)
+		assert.False(t, items[1].Starred)
+	}
+
+	// starred items are kept with KeepStarred
+	uids, err := tl.(Pruner).Prune(ctx, PruneOptions{MaxItems: 1, KeepStarred: true})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"item-1"}, uids)
+
+	uids, err = tl.(Pruner).Prune(ctx, PruneOptions{MaxItems: 1})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"item-0"}, uids)
+}
@@ -19,37 +19,63 @@
 package timeline
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 
+	"github.com/gomodule/redigo/redis"
 	"github.com/pstuifzand/ekster/pkg/microsub"
 )
 
 // ErrItemNotFound is an error for when an item is not found
 var ErrItemNotFound = errors.New("Item not found")
 
+func init() {
+	Register("null", func(ctx context.Context, channel string, pool *redis.Pool, db *sql.DB) (Backend, error) {
+		timeline := &nullTimeline{channel: channel}
+		if err := timeline.Init(ctx); err != nil {
+			return nil, err
+		}
+		return timeline, nil
+	})
+}
+
 type nullTimeline struct {
 	channel string
 }
 
-func (timeline *nullTimeline) Init() error {
+func (timeline *nullTimeline) Init(ctx context.Context) error {
 	return nil
 }
 
-func (timeline *nullTimeline) Items(before, after string) (microsub.Timeline, error) {
+func (timeline *nullTimeline) Items(ctx context.Context, before, after string, filter microsub.TimelineFilter) (microsub.Timeline, error) {
 	return microsub.Timeline{Items: []microsub.Item{}}, nil
 }
 
-func (timeline *nullTimeline) AddItem(item microsub.Item) (bool, error) {
+func (timeline *nullTimeline) AddItem(ctx context.Context, item microsub.Item) (bool, error) {
 	return false, nil
 }
 
-func (timeline *nullTimeline) Count() (int, error) {
+func (timeline *nullTimeline) Count(ctx context.Context) (int, error) {
 	return 0, nil
 }
 
-func (timeline *nullTimeline) MarkRead(uids []string) error {
+func (timeline *nullTimeline) MarkRead(ctx context.Context, uids []string) error {
+	return nil
+}
+
+func (timeline *nullTimeline) MarkReadUntil(ctx context.Context, uid string) error {
+	return nil
+}
+
+func (timeline *nullTimeline) MarkUnread(ctx context.Context, uids []string) error {
+	return nil
+}
+
+func (timeline *nullTimeline) Remove(ctx context.Context, uids []string) error {
 	return nil
 }
-func (timeline *nullTimeline) ItemsByUID(uid []string) ([]microsub.Item, error) {
+
+func (timeline *nullTimeline) ItemsByUID(ctx context.Context, uid []string) ([]microsub.Item, error) {
 	return nil, ErrItemNotFound
 }
@@ -29,21 +29,58 @@ import (
 	"strings"
 	"time"
 
+	"github.com/gomodule/redigo/redis"
 	"github.com/pstuifzand/ekster/pkg/microsub"
 
 	// load pq for postgres
 	"github.com/lib/pq"
 )
 
+func init() {
+	Register("postgres-stream", func(ctx context.Context, channel string, pool *redis.Pool, db *sql.DB) (Backend, error) {
+		timeline := &postgresStream{database: db, channel: channel}
+		if err := timeline.Init(ctx); err != nil {
+			return nil, err
+		}
+		return timeline, nil
+	})
+}
+
 type postgresStream struct {
 	database  *sql.DB
 	channel   string
 	channelID int
+	userID    int
+}
+
+// isGlobal returns true when the stream contains the items of all channels of the user
+func (p *postgresStream) isGlobal() bool {
+	return p.channel == GlobalChannel
+}
+
+// isSaved returns true when the stream contains the starred items of all
+// channels of the user
+func (p *postgresStream) isSaved() bool {
+	return p.channel == SavedChannel
+}
+
+// allChannels returns true when the stream contains items of all channels of
+// the user, instead of the items of one channel
+func (p *postgresStream) allChannels() bool {
+	return p.isGlobal() || p.isSaved()
+}
+
+// scope returns the condition on column that limits the items to this stream,
+// with the argument for parameter n.
+func (p *postgresStream) scope(column string, n int) (string, interface{}) {
+	if p.allChannels() {
+		return fmt.Sprintf(`%s IN (SELECT "id" FROM "channels" WHERE "user_id" = $%d)`, column, n), p.userID
+	}
+	return fmt.Sprintf(`%s = $%d`, column, n), p.channelID
 }
 
 // Init
-func (p *postgresStream) Init() error {
-	ctx := context.Background()
+func (p *postgresStream) Init(ctx context.Context) error {
 	conn, err := p.database.Conn(ctx)
 	if err != nil {
 		return err
@@ -54,6 +91,10 @@ func (p *postgresStream) Init() error {
 		return fmt.Errorf("database ping failed: %w", err)
 	}
 
+	if p.allChannels() {
+		return nil
+	}
+
 	row := conn.QueryRowContext(ctx, `SELECT "id" FROM "channels" WHERE "uid" = $1`, p.channel)
 	err = row.Scan(&p.channelID)
 	// if err == sql.ErrNoRows {
@@ -63,48 +104,82 @@ func (p *postgresStream) Init() error {
 	return nil
 }
 
-// Items
-func (p *postgresStream) Items(before, after string) (microsub.Timeline, error) {
-	ctx := context.Background()
+// Items returns a page of items. The before and after cursors select the
+// items that are newer or older than the item of the cursor.
+func (p *postgresStream) Items(ctx context.Context, before, after string, filter microsub.TimelineFilter) (microsub.Timeline, error) {
 	conn, err := p.database.Conn(ctx)
 	if err != nil {
 		return microsub.Timeline{}, err
 	}
 	defer conn.Close()
 
+	cond, arg := p.scope(`"i"."channel_id"`, 1)
+
 	var args []interface{}
-	args = append(args, p.channelID)
-	var qb strings.Builder
-	qb.WriteString(`
-SELECT "id", "uid", "data", "created_at", "is_read", "published_at"
-FROM "items"
-WHERE "channel_id" = $1
-`)
+	args = append(args, arg)
+	var wb strings.Builder
+	wb.WriteString(cond + ` AND "i"."is_removed" = 0`)
+	if p.isSaved() {
+		wb.WriteString(` AND "i"."is_starred" = 1`)
+	}
+	if filter.Unread {
+		wb.WriteString(` AND "i"."is_read" = 0`)
+	}
+	if filter.Source != "" {
+		feedID, err := strconv.ParseInt(filter.Source, 10, 64)
+		if err != nil {
+			return microsub.Timeline{}, fmt.Errorf("source %q is not a feed id: %w", filter.Source, err)
+		}
+		args = append(args, feedID)
+		wb.WriteString(fmt.Sprintf(` AND "i"."feed_id" = $%d`, len(args)))
+	}
+	filterWhere, filterArgs := wb.String(), args
+
+	limit := pageSize(filter.Limit)
+
+	// newer is true when the page is selected with the before cursor; the
+	// query then runs in ascending order and the result is reversed.
+	newer := false
+	where := filterWhere
 	if before != "" {
-		b, err := time.Parse(time.RFC3339, before)
+		c, err := parseBeforeCursor(before)
 		if err != nil {
-			log.Println(err)
-		} else {
-			args = append(args, b)
-			qb.WriteString(` AND "published_at" > $2`)
+			return microsub.Timeline{}, err
 		}
+		newer = true
+		args = append(args, c.Published, c.ID)
+		where += fmt.Sprintf(` AND ("i"."published_at", "i"."id") > ($%d, $%d)`, len(args)-1, len(args))
 	} else if after != "" {
-		b, err := time.Parse(time.RFC3339, after)
-		if err == nil {
-			args = append(args, b)
-			qb.WriteString(` AND "published_at" < $2`)
+		c, err := parseAfterCursor(after)
+		if err != nil {
+			return microsub.Timeline{}, err
 		}
+		args = append(args, c.Published, c.ID)
+		where += fmt.Sprintf(` AND ("i"."published_at", "i"."id") < ($%d, $%d)`, len(args)-1, len(args))
+	}
+
+	order := `DESC`
+	if newer {
+		order = `ASC`
 	}
-	qb.WriteString(` ORDER BY "published_at" DESC LIMIT 20`)
 
-	rows, err := conn.QueryContext(context.Background(), qb.String(), args...)
+	// One extra item is selected to find out if there are more items
+	query := fmt.Sprintf(`
+SELECT "i"."id", "i"."uid", "i"."data", "i"."created_at", "i"."is_read", "i"."is_starred", "i"."published_at", "c"."uid"
+FROM "items" AS "i"
+INNER JOIN "channels" AS "c" ON "c"."id" = "i"."channel_id"
+WHERE %s
+ORDER BY "i"."published_at" %s, "i"."id" %s
+LIMIT %d
+`, where, order, order, limit+1)
+
+	rows, err := conn.QueryContext(ctx, query, args...)
 	if err != nil {
 		return microsub.Timeline{}, fmt.Errorf("while query: %w", err)
 	}
 
 	var tl microsub.Timeline
-
-	var first, last string
+	var cursors []cursor
 
 	for rows.Next() {
 		var id int
@@ -112,25 +187,28 @@ WHERE "channel_id" = $1
 		var item microsub.Item
 		var createdAt time.Time
 		var isRead int
-		var publishedAt string
+		var isStarred int
+		var publishedAt time.Time
+		var channel string
 
-		err = rows.Scan(&id, &uid, &item, &createdAt, &isRead, &publishedAt)
+		err = rows.Scan(&id, &uid, &item, &createdAt, &isRead, &isStarred, &publishedAt, &channel)
 		if err != nil {
 			break
 		}
-		if first == "" {
-			first = publishedAt
-		}
-		last = publishedAt
 
 		item.Read = isRead == 1
+		item.Starred = isStarred == 1
 		item.ID = uid
-		item.Published = publishedAt
+		item.Published = publishedAt.Format(time.RFC3339Nano)
+		if p.allChannels() {
+			item.Channel = channel
+		}
 
 		tl.Items = append(tl.Items, item)
+		cursors = append(cursors, cursor{Published: publishedAt, ID: id})
 	}
 	if closeErr := rows.Close(); closeErr != nil {
-		return tl, err
+		return tl, closeErr
 	}
 	if err != nil {
 		return tl, err
@@ -139,11 +217,38 @@ WHERE "channel_id" = $1
 		return tl, err
 	}
 
-	if len(tl.Items) > 0 && hasMoreBefore(conn, tl.Items[0].Published) {
-		tl.Paging.Before = tl.Items[0].Published
+	more := len(tl.Items) > limit
+	if more {
+		tl.Items = tl.Items[:limit]
+		cursors = cursors[:limit]
 	}
-	if hasMoreAfter(conn, last) {
-		tl.Paging.After = last
+
+	if newer {
+		for i, j := 0, len(tl.Items)-1; i < j; i, j = i+1, j-1 {
+			tl.Items[i], tl.Items[j] = tl.Items[j], tl.Items[i]
+			cursors[i], cursors[j] = cursors[j], cursors[i]
+		}
+	}
+
+	if len(cursors) > 0 {
+		first, last := cursors[0], cursors[len(cursors)-1]
+
+		hasNewer, hasOlder := more, more
+		if newer {
+			hasOlder, err = p.hasItems(ctx, conn, filterWhere, filterArgs, "<", last)
+		} else {
+			hasNewer, err = p.hasItems(ctx, conn, filterWhere, filterArgs, ">", first)
+		}
+		if err != nil {
+			return tl, err
+		}
+
+		if hasNewer {
+			tl.Paging.Before = first.String()
+		}
+		if hasOlder {
+			tl.Paging.After = last.String()
+		}
 	}
 
 	if tl.Items == nil {
@@ -153,34 +258,35 @@ WHERE "channel_id" = $1
 	return tl, nil
 }
 
-func hasMoreBefore(conn *sql.Conn, before string) bool {
-	row := conn.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM "items" WHERE "published_at" > $1`, before)
-	var count int
-	if err := row.Scan(&count); err == sql.ErrNoRows {
-		return false
+// hasItems returns true when the stream contains items matching where (with
+// the filter arguments) that are newer (op is ">") or older (op is "<") than c.
+func (p *postgresStream) hasItems(ctx context.Context, conn *sql.Conn, where string, args []interface{}, op string, c cursor) (bool, error) {
+	args = append(args[:len(args):len(args)], c.Published, c.ID)
+	query := fmt.Sprintf(`
+SELECT EXISTS (
+    SELECT 1 FROM "items" AS "i"
+    WHERE %s AND ("i"."published_at", "i"."id") %s ($%d, $%d)
+)`, where, op, len(args)-1, len(args))
+	var exists bool
+	if err := conn.QueryRowContext(ctx, query, args...).Scan(&exists); err != nil {
+		return false, fmt.Errorf("while checking for more items: %w", err)
 	}
-	return count > 0
-}
-
-func hasMoreAfter(conn *sql.Conn, after string) bool {
-	row := conn.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM "items" WHERE "published_at" < $1`, after)
-	var count int
-	if err := row.Scan(&count); err == sql.ErrNoRows {
-		return false
-	}
-	return count > 0
+	return exists, nil
 }
 
 // Count
-func (p *postgresStream) Count() (int, error) {
-	ctx := context.Background()
+func (p *postgresStream) Count(ctx context.Context) (int, error) {
 	conn, err := p.database.Conn(ctx)
 	if err != nil {
 		return -1, err
 	}
 	defer conn.Close()
 	var count int
-	row := conn.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM items WHERE channel_id = $1 AND "is_read" = 0`, p.channelID)
+	cond, arg := p.scope(`"channel_id"`, 1)
+	if p.isSaved() {
+		cond += ` AND "is_starred" = 1`
+	}
+	row := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM items WHERE `+cond+` AND "is_read" = 0 AND "is_removed" = 0`, arg)
 	err = row.Scan(&count)
 	if err != nil && err == sql.ErrNoRows {
 		return 0, nil
@@ -189,21 +295,20 @@ func (p *postgresStream) Count() (int, error) {
 }
 
 // AddItem
-func (p *postgresStream) AddItem(item microsub.Item) (bool, error) {
-	ctx := context.Background()
+func (p *postgresStream) AddItem(ctx context.Context, item microsub.Item) (bool, error) {
+	if p.allChannels() {
+		return false, fmt.Errorf("items can't be added to the %s timeline", p.channel)
+	}
+
 	conn, err := p.database.Conn(ctx)
 	if err != nil {
 		return false, err
 	}
 	defer conn.Close()
 
-	t, err := time.Parse("2006-01-02T15:04:05Z0700", item.Published)
+	t, err := parsePublished(item.Published)
 	if err != nil {
-		t2, err := time.Parse("2006-01-02T15:04:05Z07:00", item.Published)
-		if err != nil {
-			return false, fmt.Errorf("while adding item: time %q could not be parsed: %w", item.Published, err)
-		}
-		t = t2
+		return false, fmt.Errorf("while adding item: %w", err)
 	}
 	if item.ID == "" {
 		// FIXME: This won't work when we receive the item multiple times
@@ -226,10 +331,12 @@ func (p *postgresStream) AddItem(item microsub.Item) (bool, error) {
 		}
 	}
 
-	result, err := conn.ExecContext(context.Background(), `
-INSERT INTO "items" ("channel_id", "feed_id", "uid", "data", "published_at", "created_at")
-VALUES ($1, $2, $3, $4, $5, DEFAULT)
-ON CONFLICT ON CONSTRAINT "items_uid_key" DO NOTHING
+	// Items that were pruned are not added again
+	result, err := conn.ExecContext(ctx, `
+INSERT INTO "items" ("channel_id", "feed_id", "uid", "data", "published_at")
+SELECT $1::int, $2::int, $3::varchar, $4::jsonb, $5::timestamptz
+WHERE NOT EXISTS (SELECT 1 FROM "pruned_items" WHERE "channel_id" = $1 AND "uid" = $3)
+ON CONFLICT ON CONSTRAINT "items_channel_id_uid_key" DO NOTHING
 `, p.channelID, optFeedID, item.ID, &item, t)
 	if err != nil {
 		return false, fmt.Errorf("insert item: %w", err)
@@ -243,38 +350,276 @@ ON CONFLICT ON CONSTRAINT "items_uid_key" DO NOTHING
 }
 
 // MarkRead
-func (p *postgresStream) MarkRead(uids []string) error {
-	ctx := context.Background()
+func (p *postgresStream) MarkRead(ctx context.Context, uids []string) error {
 	conn, err := p.database.Conn(ctx)
 	if err != nil {
 		return fmt.Errorf("getting connection: %w", err)
 	}
 	defer conn.Close()
-	_, err = conn.ExecContext(context.Background(), `UPDATE "items" SET is_read = 1 WHERE "uid" = ANY($1)`, pq.Array(uids))
+	cond, arg := p.scope(`"channel_id"`, 1)
+	_, err = conn.ExecContext(ctx, `UPDATE "items" SET is_read = 1 WHERE `+cond+` AND "uid" = ANY($2)`, arg, pq.Array(uids))
 	if err != nil {
 		return fmt.Errorf("while marking as read: %w", err)
 	}
 	return nil
 }
 
+// MarkReadUntil marks the item with uid and all items before it in the
+// timeline order (published date and id) as read
+func (p *postgresStream) MarkReadUntil(ctx context.Context, uid string) error {
+	conn, err := p.database.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("getting connection: %w", err)
+	}
+	defer conn.Close()
+	cond, arg := p.scope(`"channel_id"`, 1)
+
+	var publishedAt time.Time
+	var id int
+	err = conn.QueryRowContext(ctx, `
+SELECT "published_at", "id" FROM "items" WHERE `+cond+` AND "uid" = $2
+ORDER BY "published_at" DESC, "id" DESC
+LIMIT 1
+`, arg, uid).Scan(&publishedAt, &id)
+	if err == sql.ErrNoRows {
+		return ErrItemNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("while marking as read: %w", err)
+	}
+
+	_, err = conn.ExecContext(ctx, `
+UPDATE "items" SET is_read = 1
+WHERE `+cond+` AND "is_read" = 0 AND ("published_at", "id") <= ($2, $3)
+`, arg, publishedAt, id)
+	if err != nil {
+		return fmt.Errorf("while marking as read: %w", err)
+	}
+	return nil
+}
+
+// MarkUnread
+func (p *postgresStream) MarkUnread(ctx context.Context, uids []string) error {
+	conn, err := p.database.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("getting connection: %w", err)
+	}
+	defer conn.Close()
+	cond, arg := p.scope(`"channel_id"`, 1)
+	_, err = conn.ExecContext(ctx, `UPDATE "items" SET is_read = 0 WHERE `+cond+` AND "uid" = ANY($2)`, arg, pq.Array(uids))
+	if err != nil {
+		return fmt.Errorf("while marking as unread: %w", err)
+	}
+	return nil
+}
+
+// Remove hides the items from the channel. The rows are kept, so the items
+// are not added again when the feed is fetched.
+func (p *postgresStream) Remove(ctx context.Context, uids []string) error {
+	conn, err := p.database.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("getting connection: %w", err)
+	}
+	defer conn.Close()
+	cond, arg := p.scope(`"channel_id"`, 1)
+	_, err = conn.ExecContext(ctx, `UPDATE "items" SET is_removed = 1 WHERE `+cond+` AND "uid" = ANY($2)`, arg, pq.Array(uids))
+	if err != nil {
+		return fmt.Errorf("while removing: %w", err)
+	}
+	return nil
+}
+
+// RemovedItems returns the removed items
+func (p *postgresStream) RemovedItems(ctx context.Context) ([]microsub.Item, error) {
+	cond, arg := p.scope(`"channel_id"`, 1)
+	rows, err := p.database.QueryContext(ctx, `
+SELECT "uid", "data", "published_at" FROM "items"
+WHERE `+cond+` AND "is_removed" = 1
+ORDER BY "published_at", "id"
+`, arg)
+	if err != nil {
+		return nil, fmt.Errorf("while reading removed items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []microsub.Item
+	for rows.Next() {
+		var item microsub.Item
+		var publishedAt time.Time
+		if err := rows.Scan(&item.ID, &item, &publishedAt); err != nil {
+			return nil, fmt.Errorf("while reading removed items: %w", err)
+		}
+		item.Published = publishedAt.Format(time.RFC3339Nano)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Star marks the items as starred
+func (p *postgresStream) Star(ctx context.Context, uids []string) error {
+	return p.setStarred(ctx, uids, 1)
+}
+
+// Unstar removes the star from the items
+func (p *postgresStream) Unstar(ctx context.Context, uids []string) error {
+	return p.setStarred(ctx, uids, 0)
+}
+
+func (p *postgresStream) setStarred(ctx context.Context, uids []string, starred int) error {
+	conn, err := p.database.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("getting connection: %w", err)
+	}
+	defer conn.Close()
+	cond, arg := p.scope(`"channel_id"`, 1)
+	_, err = conn.ExecContext(ctx, `UPDATE "items" SET "is_starred" = $3 WHERE `+cond+` AND "uid" = ANY($2)`, arg, pq.Array(uids), starred)
+	if err != nil {
+		return fmt.Errorf("while starring: %w", err)
+	}
+	return nil
+}
+
+// Prune deletes the items selected by opts. The uids of the deleted items are
+// saved in "pruned_items".
+func (p *postgresStream) Prune(ctx context.Context, opts PruneOptions) ([]string, error) {
+	if p.allChannels() {
+		return nil, fmt.Errorf("items can't be pruned from the %s timeline", p.channel)
+	}
+
+	tx, err := p.database.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var before interface{}
+	if !opts.Before.IsZero() {
+		before = opts.Before
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+DELETE FROM "items" WHERE "id" IN (
+    SELECT "id" FROM (
+        SELECT "id", "is_read", "is_removed", "is_starred", "published_at",
+               row_number() OVER (ORDER BY "published_at" DESC, "id" DESC) AS "n"
+        FROM "items"
+        WHERE "channel_id" = $1
+    ) AS "ranked"
+    WHERE ("published_at" < $2::timestamptz OR ($3 > 0 AND "n" > $3))
+      AND (NOT $4 OR "is_read" = 1 OR "is_removed" = 1)
+      AND (NOT $5 OR "is_starred" = 0)
+)
+RETURNING "uid"
+`, p.channelID, before, opts.MaxItems, opts.KeepUnread, opts.KeepStarred)
+	if err != nil {
+		return nil, fmt.Errorf("while pruning: %w", err)
+	}
+	defer rows.Close()
+
+	var uids []string
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			return nil, fmt.Errorf("while scanning pruned item: %w", err)
+		}
+		uids = append(uids, uid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("while pruning: %w", err)
+	}
+
+	if len(uids) == 0 {
+		return nil, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO "pruned_items" ("channel_id", "uid")
+SELECT $1::int, unnest($2::varchar[])
+ON CONFLICT DO NOTHING
+`, p.channelID, pq.Array(uids))
+	if err != nil {
+		return nil, fmt.Errorf("while saving pruned items: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit prune: %w", err)
+	}
+
+	return uids, nil
+}
+
+// Delete deletes the items with the uids. The uids are saved with the pruned
+// items, so they are not added again.
+func (p *postgresStream) Delete(ctx context.Context, uids []string) error {
+	if p.allChannels() {
+		return fmt.Errorf("items can't be deleted from the %s timeline", p.channel)
+	}
+	if len(uids) == 0 {
+		return nil
+	}
+
+	tx, err := p.database.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM "items" WHERE "channel_id" = $1 AND "uid" = ANY($2)`, p.channelID, pq.Array(uids))
+	if err != nil {
+		return fmt.Errorf("while deleting: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO "pruned_items" ("channel_id", "uid")
+SELECT $1::int, unnest($2::varchar[])
+ON CONFLICT DO NOTHING
+`, p.channelID, pq.Array(uids))
+	if err != nil {
+		return fmt.Errorf("while saving deleted items: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit delete: %w", err)
+	}
+
+	return nil
+}
+
+// Clear deletes all items of the channel. The pruned and deleted items are
+// remembered, so they are not added again.
+func (p *postgresStream) Clear(ctx context.Context) error {
+	if p.allChannels() {
+		return fmt.Errorf("the %s timeline can't be cleared", p.channel)
+	}
+	_, err := p.database.ExecContext(ctx, `DELETE FROM "items" WHERE "channel_id" = $1`, p.channelID)
+	if err != nil {
+		return fmt.Errorf("while clearing: %w", err)
+	}
+	return nil
+}
+
 // Item returns the item with id for this channel
-func (p *postgresStream) ItemsByUID(uids []string) ([]microsub.Item, error) {
+func (p *postgresStream) ItemsByUID(ctx context.Context, uids []string) ([]microsub.Item, error) {
 
 	var items []microsub.Item
 
+	cond, arg := p.scope(`"channel_id"`, 2)
+
 	for _, uid := range uids {
 		var item microsub.Item
 		var createdAt time.Time
 		var isRead int
+		var isStarred int
 		var publishedAt string
 
-		row := p.database.QueryRow(`
-			SELECT  "data", "created_at", "is_read", "published_at"
+		row := p.database.QueryRowContext(ctx, `
+			SELECT  "data", "created_at", "is_read", "is_starred", "published_at"
 			FROM "items"
-			WHERE "uid" = $1
-		`, uid)
+			WHERE "uid" = $1 AND `+cond+` AND "is_removed" = 0
+			LIMIT 1
+		`, uid, arg)
 
-		err := row.Scan(&item, &createdAt, &isRead, &publishedAt)
+		err := row.Scan(&item, &createdAt, &isRead, &isStarred, &publishedAt)
 		if err == sql.ErrNoRows {
 			return nil, ErrItemNotFound
 		} else if err != nil {
@@ -283,6 +628,7 @@ func (p *postgresStream) ItemsByUID(uids []string) ([]microsub.Item, error) {
 		}
 
 		item.Read = isRead == 1
+		item.Starred = isStarred == 1
 		item.ID = uid
 		item.Published = publishedAt
 		items = append(items, item)
@@ -19,124 +19,257 @@
 package timeline
 
 import (
+	"context"
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/pstuifzand/ekster/pkg/microsub"
 )
 
+func init() {
+	Register("sorted-set", func(ctx context.Context, channel string, pool *redis.Pool, db *sql.DB) (Backend, error) {
+		timeline := &redisSortedSetTimeline{channel: channel, pool: pool}
+		if err := timeline.Init(ctx); err != nil {
+			return nil, err
+		}
+		return timeline, nil
+	})
+}
+
+// redisSortedSetTimeline keeps the unread items of a channel in a sorted set,
+// with the published date as score. Read items are removed from the sorted
+// set, so only unread items are returned from Items.
 type redisSortedSetTimeline struct {
 	channel string
 	pool    *redis.Pool
 }
 
+// setCursor is the position of an item in the sorted set. Redis sorts items
+// with the same score by member, which makes the order stable.
+type setCursor struct {
+	Score  int64
+	Member string
+}
+
+func (c setCursor) String() string {
+	s := fmt.Sprintf("%d,%s", c.Score, c.Member)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func parseSetCursor(s string) (setCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return setCursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
+	}
+	parts := strings.SplitN(string(b), ",", 2)
+	if len(parts) != 2 {
+		return setCursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
+	}
+	score, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return setCursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
+	}
+	return setCursor{Score: score, Member: parts[1]}, nil
+}
+
+// less returns true when the item at c is older than the item at o
+func (c setCursor) less(o setCursor) bool {
+	if c.Score == o.Score {
+		return c.Member < o.Member
+	}
+	return c.Score < o.Score
+}
+
 /*
  * REDIS SORTED SETS TIMELINE
  */
-func (timeline *redisSortedSetTimeline) Init() error {
+func (timeline *redisSortedSetTimeline) Init(ctx context.Context) error {
 	return nil
 }
 
-func (timeline *redisSortedSetTimeline) Items(before, after string) (microsub.Timeline, error) {
-	conn := timeline.pool.Get()
-	defer conn.Close()
+func (timeline *redisSortedSetTimeline) postsKey() string {
+	return fmt.Sprintf("zchannel:%s:posts", timeline.channel)
+}
 
-	items := []microsub.Item{}
+func (timeline *redisSortedSetTimeline) readKey() string {
+	return fmt.Sprintf("channel:%s:read", timeline.channel)
+}
 
-	channel := timeline.channel
+func (timeline *redisSortedSetTimeline) removedKey() string {
+	return fmt.Sprintf("channel:%s:removed", timeline.channel)
+}
 
-	zchannelKey := fmt.Sprintf("zchannel:%s:posts", channel)
+// dataKey is the hash with the data of the item with itemKey in this channel.
+// The same item can be added to more than one channel.
+func (timeline *redisSortedSetTimeline) dataKey(itemKey string) string {
+	return fmt.Sprintf("channel:%s:%s", timeline.channel, itemKey)
+}
 
-	afterScore := "-inf"
-	if len(after) != 0 {
-		afterScore = "(" + after
+// Items returns a page of unread items
+func (timeline *redisSortedSetTimeline) Items(ctx context.Context, before, after string, filter microsub.TimelineFilter) (microsub.Timeline, error) {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return microsub.Timeline{}, err
 	}
-	beforeScore := "+inf"
-	if len(before) != 0 {
-		beforeScore = "(" + before
+	defer conn.Close()
+
+	limit := pageSize(filter.Limit)
+
+	// newer is true when the page is selected with the before cursor; the
+	// items are then scanned from old to new and the result is reversed.
+	newer := before != ""
+	var c *setCursor
+	if before != "" || after != "" {
+		s := after
+		if newer {
+			s = before
+		}
+		parsed, err := parseSetCursor(s)
+		if err != nil {
+			return microsub.Timeline{}, err
+		}
+		c = &parsed
 	}
 
-	var itemJSONs [][]byte
+	var items []microsub.Item
+	var cursors []setCursor
+
+	// Items are scanned in batches, because items that don't match the filter
+	// are skipped. The score range is inclusive, items with the same score as
+	// the cursor are compared on their member.
+	batch := limit + 1
+	for offset := 0; len(items) <= limit; offset += batch {
+		cmd, args := "ZREVRANGEBYSCORE", redis.Args{}.Add(timeline.postsKey(), "+inf", "-inf")
+		if newer {
+			cmd, args = "ZRANGEBYSCORE", redis.Args{}.Add(timeline.postsKey(), c.Score, "+inf")
+		} else if c != nil {
+			args = redis.Args{}.Add(timeline.postsKey(), c.Score, "-inf")
+		}
+		args = args.Add("WITHSCORES", "LIMIT", offset, batch)
 
-	itemScores, err := redis.Strings(
-		conn.Do(
-			"ZRANGEBYSCORE",
-			zchannelKey,
-			afterScore,
-			beforeScore,
-			"LIMIT",
-			0,
-			20,
-			"WITHSCORES",
-		),
-	)
+		values, err := redis.Strings(conn.Do(cmd, args...))
+		if err != nil {
+			return microsub.Timeline{}, fmt.Errorf("while reading items of %s: %w", timeline.channel, err)
+		}
+		if len(values) == 0 {
+			break
+		}
 
-	if err != nil {
-		return microsub.Timeline{
-			Paging: microsub.Pagination{},
-			Items:  items,
-		}, err
+		for i := 0; i+1 < len(values) && len(items) <= limit; i += 2 {
+			score, err := strconv.ParseInt(values[i+1], 10, 64)
+			if err != nil {
+				return microsub.Timeline{}, fmt.Errorf("while reading items of %s: %w", timeline.channel, err)
+			}
+			ic := setCursor{Score: score, Member: values[i]}
+			if c != nil && ((newer && !c.less(ic)) || (!newer && !ic.less(*c))) {
+				continue
+			}
+
+			item, err := timeline.loadItem(conn, ic.Member)
+			if err != nil {
+				return microsub.Timeline{}, err
+			}
+			// the items in the sorted set are unread, the data can be older
+			item.Read = false
+			if filter.Source != "" && (item.Source == nil || item.Source.ID != filter.Source) {
+				continue
+			}
+
+			items = append(items, item)
+			cursors = append(cursors, ic)
+		}
 	}
 
-	if len(itemScores) >= 2 {
-		before = itemScores[1]
-		after = itemScores[len(itemScores)-1]
-	} else {
-		before = ""
-		after = ""
+	more := len(items) > limit
+	if more {
+		items = items[:limit]
+		cursors = cursors[:limit]
 	}
 
-	for i := 0; i < len(itemScores); i += 2 {
-		itemID := itemScores[i]
-		itemJSON, err := redis.Bytes(conn.Do("HGET", itemID, "Data"))
-		if err != nil {
-			log.Println(err)
-			continue
+	if newer {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+			cursors[i], cursors[j] = cursors[j], cursors[i]
 		}
-		itemJSONs = append(itemJSONs, itemJSON)
 	}
 
-	for _, obj := range itemJSONs {
-		item := microsub.Item{}
-		err := json.Unmarshal(obj, &item)
-		if err != nil {
-			// FIXME: what should we do if one of the items doen't unmarshal?
-			log.Println(err)
-			continue
+	tl := microsub.Timeline{Items: items}
+	if tl.Items == nil {
+		tl.Items = []microsub.Item{}
+	}
+
+	if len(cursors) > 0 {
+		// In the opposite direction of the scan there is at least the item of
+		// the cursor, unless it was marked read in the mean time.
+		hasNewer, hasOlder := more, more
+		if newer {
+			hasOlder = true
+		} else {
+			hasNewer = c != nil
 		}
-		item.Read = false
-		items = append(items, item)
+		if hasNewer {
+			tl.Paging.Before = cursors[0].String()
+		}
+		if hasOlder {
+			tl.Paging.After = cursors[len(cursors)-1].String()
+		}
+	}
+
+	return tl, nil
+}
+
+func (timeline *redisSortedSetTimeline) loadItem(conn redis.Conn, itemKey string) (microsub.Item, error) {
+	var item microsub.Item
+	data, err := timeline.itemField(conn, itemKey, "Data")
+	if err != nil {
+		return item, err
 	}
-	paging := microsub.Pagination{
-		After:  after,
-		Before: before,
+	if err = json.Unmarshal(data, &item); err != nil {
+		return item, fmt.Errorf("while reading %s: %w", itemKey, err)
 	}
+	return item, nil
+}
 
-	return microsub.Timeline{
-		Paging: paging,
-		Items:  items,
-	}, nil
+// itemField returns a field of the item with itemKey. Items that were added
+// before the data was kept per channel, are read from the shared hash.
+func (timeline *redisSortedSetTimeline) itemField(conn redis.Conn, itemKey, field string) ([]byte, error) {
+	value, err := redis.Bytes(conn.Do("HGET", timeline.dataKey(itemKey), field))
+	if err == redis.ErrNil {
+		value, err = redis.Bytes(conn.Do("HGET", itemKey, field))
+	}
+	if err == redis.ErrNil {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s: %w", itemKey, err)
+	}
+	return value, nil
 }
 
-func (timeline *redisSortedSetTimeline) AddItem(item microsub.Item) (bool, error) {
-	conn := timeline.pool.Get()
+// AddItem adds an unread item, returns false when the item was added or read before
+func (timeline *redisSortedSetTimeline) AddItem(ctx context.Context, item microsub.Item) (bool, error) {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return false, err
+	}
 	defer conn.Close()
 
-	channel := timeline.channel
-	zchannelKey := fmt.Sprintf("zchannel:%s:posts", channel)
-
 	if item.Published == "" {
 		item.Published = time.Now().Format(time.RFC3339)
 	}
 
-	// Fix date when it almost matches with RFC3339, except the colon in the timezone
-	format := "2006-01-02T15:04:05Z0700"
-	if parsedDate, err := time.Parse(format, item.Published); err == nil {
-		item.Published = parsedDate.Format(time.RFC3339)
+	published, err := parsePublished(item.Published)
+	if err != nil {
+		return false, fmt.Errorf("while adding item: %w", err)
 	}
+	// Fix date when it almost matches with RFC3339, except the colon in the timezone
+	item.Published = published.Format(time.RFC3339)
 
 	data, err := json.Marshal(item)
 	if err != nil {
@@ -151,13 +284,12 @@ func (timeline *redisSortedSetTimeline) AddItem(item microsub.Item) (bool, error
 	}
 
 	itemKey := fmt.Sprintf("item:%s", item.ID)
-	_, err = redis.String(conn.Do("HMSET", redis.Args{}.Add(itemKey).AddFlat(&forRedis)...))
+	_, err = redis.String(conn.Do("HMSET", redis.Args{}.Add(timeline.dataKey(itemKey)).AddFlat(&forRedis)...))
 	if err != nil {
 		return false, fmt.Errorf("writing failed for item to redis: %v", err)
 	}
 
-	readChannelKey := fmt.Sprintf("channel:%s:read", channel)
-	isRead, err := redis.Bool(conn.Do("SISMEMBER", readChannelKey, itemKey))
+	isRead, err := redis.Bool(conn.Do("SISMEMBER", timeline.readKey(), itemKey))
 	if err != nil {
 		return false, err
 	}
@@ -166,34 +298,36 @@ func (timeline *redisSortedSetTimeline) AddItem(item microsub.Item) (bool, error
 		return false, nil
 	}
 
-	score, err := time.Parse(time.RFC3339, item.Published)
-	if err != nil {
-		return false, fmt.Errorf("can't parse %s as time", item.Published)
-	}
-
-	n, err := redis.Int64(conn.Do("ZADD", zchannelKey, score.Unix()*1.0, itemKey))
+	n, err := redis.Int64(conn.Do("ZADD", timeline.postsKey(), published.Unix(), itemKey))
 	if err != nil {
-		return false, fmt.Errorf("zadding failed item %s to channel %s for redis: %v", itemKey, zchannelKey, err)
+		return false, fmt.Errorf("zadding failed item %s to channel %s for redis: %v", itemKey, timeline.postsKey(), err)
 	}
 
 	return n == 1, nil
 }
 
-func (timeline *redisSortedSetTimeline) Count() (int, error) {
-	conn := timeline.pool.Get()
+// Count returns the number of unread items
+func (timeline *redisSortedSetTimeline) Count(ctx context.Context) (int, error) {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return -1, err
+	}
 	defer conn.Close()
 
 	channel := timeline.channel
-	zchannelKey := fmt.Sprintf("zchannel:%s:posts", channel)
-	unread, err := redis.Int(conn.Do("ZCARD", zchannelKey))
+	unread, err := redis.Int(conn.Do("ZCARD", timeline.postsKey()))
 	if err != nil {
 		return -1, fmt.Errorf("while updating channel unread count for %s: %s", channel, err)
 	}
 	return unread, nil
 }
 
-func (timeline *redisSortedSetTimeline) MarkRead(uids []string) error {
-	conn := timeline.pool.Get()
+// MarkRead marks the items as read, which removes them from the sorted set
+func (timeline *redisSortedSetTimeline) MarkRead(ctx context.Context, uids []string) error {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
 	defer conn.Close()
 
 	channel := timeline.channel
@@ -203,15 +337,13 @@ func (timeline *redisSortedSetTimeline) MarkRead(uids []string) error {
 		itemUIDs = append(itemUIDs, "item:"+uid)
 	}
 
-	channelKey := fmt.Sprintf("channel:%s:read", channel)
-	args := redis.Args{}.Add(channelKey).AddFlat(itemUIDs)
+	args := redis.Args{}.Add(timeline.readKey()).AddFlat(itemUIDs)
 
 	if _, err := conn.Do("SADD", args...); err != nil {
 		return fmt.Errorf("marking read for channel %s has failed: %s", channel, err)
 	}
 
-	zchannelKey := fmt.Sprintf("zchannel:%s:posts", channel)
-	args = redis.Args{}.Add(zchannelKey).AddFlat(itemUIDs)
+	args = redis.Args{}.Add(timeline.postsKey()).AddFlat(itemUIDs)
 
 	if _, err := conn.Do("ZREM", args...); err != nil {
 		return fmt.Errorf("marking read for channel %s has failed: %s", channel, err)
@@ -220,6 +352,253 @@ func (timeline *redisSortedSetTimeline) MarkRead(uids []string) error {
 	return nil
 }
 
-func (timeline *redisSortedSetTimeline) MarkUnread(uids []string) error {
-	panic("implement me")
+// MarkReadUntil marks the item with uid and all items before it in the
+// timeline order (score and member) as read. Nothing happens when the item
+// is already read.
+func (timeline *redisSortedSetTimeline) MarkReadUntil(ctx context.Context, uid string) error {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	channel := timeline.channel
+	zchannelKey := timeline.postsKey()
+	itemKey := "item:" + uid
+
+	score, err := redis.Int64(conn.Do("ZSCORE", zchannelKey, itemKey))
+	if err == redis.ErrNil {
+		read, err := redis.Bool(conn.Do("SISMEMBER", timeline.readKey(), itemKey))
+		if err != nil {
+			return fmt.Errorf("marking read for channel %s has failed: %s", channel, err)
+		}
+		if !read {
+			return ErrItemNotFound
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("marking read for channel %s has failed: %s", channel, err)
+	}
+	last := setCursor{Score: score, Member: itemKey}
+
+	values, err := redis.Strings(conn.Do("ZRANGEBYSCORE", zchannelKey, "-inf", score, "WITHSCORES"))
+	if err != nil {
+		return fmt.Errorf("marking read for channel %s has failed: %s", channel, err)
+	}
+
+	var uids []string
+	for i := 0; i+1 < len(values); i += 2 {
+		s, err := strconv.ParseInt(values[i+1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("marking read for channel %s has failed: %w", channel, err)
+		}
+		if last.less(setCursor{Score: s, Member: values[i]}) {
+			continue
+		}
+		uids = append(uids, strings.TrimPrefix(values[i], "item:"))
+	}
+	if len(uids) == 0 {
+		return nil
+	}
+
+	return timeline.MarkRead(ctx, uids)
+}
+
+// MarkUnread adds the items to the sorted set again
+func (timeline *redisSortedSetTimeline) MarkUnread(ctx context.Context, uids []string) error {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	channel := timeline.channel
+	readChannelKey := timeline.readKey()
+	zchannelKey := timeline.postsKey()
+
+	for _, uid := range uids {
+		itemKey := "item:" + uid
+
+		removed, err := redis.Bool(conn.Do("SISMEMBER", timeline.removedKey(), itemKey))
+		if err != nil {
+			return fmt.Errorf("marking unread for channel %s has failed: %s", channel, err)
+		}
+		if removed {
+			continue
+		}
+
+		published, err := timeline.itemField(conn, itemKey, "Published")
+		if err == ErrItemNotFound {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("marking unread for channel %s has failed: %s", channel, err)
+		}
+		score, err := parsePublished(string(published))
+		if err != nil {
+			return fmt.Errorf("marking unread for channel %s has failed: %w", channel, err)
+		}
+
+		if _, err := conn.Do("SREM", readChannelKey, itemKey); err != nil {
+			return fmt.Errorf("marking unread for channel %s has failed: %s", channel, err)
+		}
+		if _, err := conn.Do("ZADD", zchannelKey, score.Unix(), itemKey); err != nil {
+			return fmt.Errorf("marking unread for channel %s has failed: %s", channel, err)
+		}
+	}
+
+	return nil
+}
+
+// Remove hides the items from the channel. Removed items are kept in the read
+// set, so they are not added again.
+func (timeline *redisSortedSetTimeline) Remove(ctx context.Context, uids []string) error {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	itemKeys := []string{}
+	for _, uid := range uids {
+		itemKeys = append(itemKeys, "item:"+uid)
+	}
+
+	if _, err := conn.Do("SADD", redis.Args{}.Add(timeline.removedKey()).AddFlat(itemKeys)...); err != nil {
+		return fmt.Errorf("removing items from channel %s has failed: %s", timeline.channel, err)
+	}
+
+	return timeline.MarkRead(ctx, uids)
+}
+
+// RemovedItems returns the removed items
+func (timeline *redisSortedSetTimeline) RemovedItems(ctx context.Context) ([]microsub.Item, error) {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	itemKeys, err := redis.Strings(conn.Do("SMEMBERS", timeline.removedKey()))
+	if err != nil {
+		return nil, fmt.Errorf("while reading removed items of %s: %w", timeline.channel, err)
+	}
+
+	var items []microsub.Item
+	for _, itemKey := range itemKeys {
+		item, err := timeline.loadItem(conn, itemKey)
+		if err == ErrItemNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Clear deletes the sorted set, the read and removed sets and the data of the
+// items of the channel
+func (timeline *redisSortedSetTimeline) Clear(ctx context.Context) error {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unread, err := redis.Strings(conn.Do("ZRANGE", timeline.postsKey(), 0, -1))
+	if err != nil {
+		return fmt.Errorf("clearing channel %s has failed: %s", timeline.channel, err)
+	}
+	// removed items are also in the read set
+	read, err := redis.Strings(conn.Do("SMEMBERS", timeline.readKey()))
+	if err != nil {
+		return fmt.Errorf("clearing channel %s has failed: %s", timeline.channel, err)
+	}
+
+	keys := []string{timeline.postsKey(), timeline.readKey(), timeline.removedKey()}
+	for _, itemKey := range append(unread, read...) {
+		keys = append(keys, timeline.dataKey(itemKey))
+	}
+	if _, err := conn.Do("DEL", redis.Args{}.AddFlat(keys)...); err != nil {
+		return fmt.Errorf("clearing channel %s has failed: %s", timeline.channel, err)
+	}
+	return nil
+}
+
+// ReadItems returns the read items, which are not in the sorted set
+func (timeline *redisSortedSetTimeline) ReadItems(ctx context.Context) ([]microsub.Item, error) {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	itemKeys, err := redis.Strings(conn.Do("SDIFF", timeline.readKey(), timeline.removedKey()))
+	if err != nil {
+		return nil, fmt.Errorf("while reading read items of %s: %w", timeline.channel, err)
+	}
+
+	var items []microsub.Item
+	for _, itemKey := range itemKeys {
+		item, err := timeline.loadItem(conn, itemKey)
+		if err == ErrItemNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		item.Read = true
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// ItemsByUID returns the items with the uids, read or unread
+func (timeline *redisSortedSetTimeline) ItemsByUID(ctx context.Context, uids []string) ([]microsub.Item, error) {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var items []microsub.Item
+	for _, uid := range uids {
+		itemKey := "item:" + uid
+
+		removed, err := redis.Bool(conn.Do("SISMEMBER", timeline.removedKey(), itemKey))
+		if err != nil {
+			return nil, fmt.Errorf("while reading %s: %w", itemKey, err)
+		}
+		if removed {
+			return nil, ErrItemNotFound
+		}
+
+		// Only items of this channel are returned, unread items are in the
+		// sorted set and read items in the read set
+		_, err = redis.String(conn.Do("ZSCORE", timeline.postsKey(), itemKey))
+		if err != nil && err != redis.ErrNil {
+			return nil, fmt.Errorf("while reading %s: %w", itemKey, err)
+		}
+		read := err == redis.ErrNil
+		if read {
+			isRead, err := redis.Bool(conn.Do("SISMEMBER", timeline.readKey(), itemKey))
+			if err != nil {
+				return nil, fmt.Errorf("while reading %s: %w", itemKey, err)
+			}
+			if !isRead {
+				return nil, ErrItemNotFound
+			}
+		}
+
+		item, err := timeline.loadItem(conn, itemKey)
+		if err != nil {
+			return nil, err
+		}
+		item.Read = read
+		items = append(items, item)
+	}
+	return items, nil
 }
@@ -19,6 +19,10 @@
 package timeline
 
 import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -28,74 +32,222 @@ import (
 	"github.com/pstuifzand/ekster/pkg/microsub"
 )
 
+func init() {
+	Register("stream", func(ctx context.Context, channel string, pool *redis.Pool, db *sql.DB) (Backend, error) {
+		timeline := &redisStreamTimeline{channel: channel, pool: pool}
+		if err := timeline.Init(ctx); err != nil {
+			return nil, err
+		}
+		return timeline, nil
+	})
+}
+
+// redisStreamTimeline keeps the items of a channel in a Redis stream, in the
+// order they were added. The read state is kept in a set of unread uids.
 type redisStreamTimeline struct {
 	channel, channelKey string
 
 	pool *redis.Pool
 }
 
+type streamEntry struct {
+	ID   string
+	Item redisItem
+}
+
 /*
  * REDIS STREAMS TIMELINE
  */
-func (timeline *redisStreamTimeline) Init() error {
+func (timeline *redisStreamTimeline) Init(ctx context.Context) error {
 	timeline.channelKey = fmt.Sprintf("stream:%s", timeline.channel)
 	return nil
 }
 
-func (timeline *redisStreamTimeline) Items(before, after string) (microsub.Timeline, error) {
-	conn := timeline.pool.Get()
-	defer conn.Close()
+// idsKey is the hash from item uid to stream entry id
+func (timeline *redisStreamTimeline) idsKey() string {
+	return timeline.channelKey + ":ids"
+}
 
-	if before == "" {
-		before = "-"
-	}
+func (timeline *redisStreamTimeline) unreadKey() string {
+	return timeline.channelKey + ":unread"
+}
 
-	if after == "" {
-		after = "+"
-	}
+func (timeline *redisStreamTimeline) removedKey() string {
+	return timeline.channelKey + ":removed"
+}
 
-	results, err := redis.Values(conn.Do("XREVRANGE", redis.Args{}.Add(timeline.channelKey, after, before, "COUNT", "20")...))
+// Items returns a page of items. The cursors are stream entry ids.
+func (timeline *redisStreamTimeline) Items(ctx context.Context, before, after string, filter microsub.TimelineFilter) (microsub.Timeline, error) {
+	conn, err := timeline.pool.GetContext(ctx)
 	if err != nil {
 		return microsub.Timeline{}, err
 	}
+	defer conn.Close()
 
-	var forRedis redisItem
+	limit := pageSize(filter.Limit)
+
+	// newer is true when the page is selected with the before cursor; the
+	// entries are then scanned from old to new and the result is reversed.
+	newer := before != ""
+	pos := "+"
+	if newer {
+		pos = before
+	} else if after != "" {
+		pos = after
+	}
+	cursor := pos
 
 	var items []microsub.Item
-	for _, result := range results {
-		if value, ok := result.([]interface{}); ok {
-			id, ok2 := value[0].([]uint8)
-
-			if item, ok3 := value[1].([]interface{}); ok3 {
-				err = redis.ScanStruct(item, &forRedis)
-				if err != nil {
-					continue
-				}
-				item := forRedis.Item()
-				if ok2 {
-					item.ID = string(id)
-				}
-				items = append(items, item)
+	var ids []string
+
+	// Entries are scanned in batches, because entries that don't match the
+	// filter are skipped. The ranges are inclusive, so the entry at pos is
+	// skipped.
+	batch := limit + 1
+	for len(items) <= limit {
+		var reply interface{}
+		if newer {
+			reply, err = conn.Do("XRANGE", timeline.channelKey, pos, "+", "COUNT", batch)
+		} else {
+			reply, err = conn.Do("XREVRANGE", timeline.channelKey, pos, "-", "COUNT", batch)
+		}
+		entries, err := parseStreamEntries(reply, err)
+		if err != nil {
+			return microsub.Timeline{}, fmt.Errorf("while reading items of %s: %w", timeline.channel, err)
+		}
+
+		for _, entry := range entries {
+			if entry.ID == pos || len(items) > limit {
+				continue
+			}
+
+			uid := entry.Item.ID
+			removed, err := redis.Bool(conn.Do("SISMEMBER", timeline.removedKey(), uid))
+			if err != nil {
+				return microsub.Timeline{}, err
 			}
+			if removed {
+				continue
+			}
+			unread, err := redis.Bool(conn.Do("SISMEMBER", timeline.unreadKey(), uid))
+			if err != nil {
+				return microsub.Timeline{}, err
+			}
+			if filter.Unread && !unread {
+				continue
+			}
+
+			item := entry.Item.Item()
+			item.ID = uid
+			item.Read = !unread
+			if filter.Source != "" && (item.Source == nil || item.Source.ID != filter.Source) {
+				continue
+			}
+
+			items = append(items, item)
+			ids = append(ids, entry.ID)
+		}
+
+		if len(entries) < batch {
+			break
+		}
+		pos = entries[len(entries)-1].ID
+	}
+
+	more := len(items) > limit
+	if more {
+		items = items[:limit]
+		ids = ids[:limit]
+	}
+
+	if newer {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+			ids[i], ids[j] = ids[j], ids[i]
+		}
+	}
+
+	tl := microsub.Timeline{Items: items}
+	if tl.Items == nil {
+		tl.Items = []microsub.Item{}
+	}
+
+	if len(ids) > 0 {
+		// In the opposite direction of the scan there is at least the entry
+		// of the cursor.
+		hasNewer, hasOlder := more, more
+		if newer {
+			hasOlder = true
+		} else {
+			hasNewer = cursor != "+"
+		}
+		if hasNewer {
+			tl.Paging.Before = ids[0]
 		}
+		if hasOlder {
+			tl.Paging.After = ids[len(ids)-1]
+		}
+	}
+
+	return tl, nil
+}
+
+// parseStreamEntries parses the reply of XRANGE and XREVRANGE
+func parseStreamEntries(reply interface{}, err error) ([]streamEntry, error) {
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, err
 	}
 
-	return microsub.Timeline{
-		Items: items,
-		Paging: microsub.Pagination{
-			After: items[len(items)-1].ID,
-		},
-	}, nil
+	var entries []streamEntry
+	for _, value := range values {
+		fields, err := redis.Values(value, nil)
+		if err != nil || len(fields) != 2 {
+			return nil, fmt.Errorf("unexpected stream entry: %v", value)
+		}
+		id, err := redis.String(fields[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := redis.Values(fields[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		entry := streamEntry{ID: id}
+		if err = redis.ScanStruct(kv, &entry.Item); err != nil {
+			log.Printf("while reading stream entry %s: %v", id, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
 }
 
-func (timeline *redisStreamTimeline) AddItem(item microsub.Item) (bool, error) {
-	conn := timeline.pool.Get()
+// AddItem adds an item, returns false when the item was added before
+func (timeline *redisStreamTimeline) AddItem(ctx context.Context, item microsub.Item) (bool, error) {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return false, err
+	}
 	defer conn.Close()
 
 	if item.Published == "" {
 		item.Published = time.Now().Format(time.RFC3339)
 	}
 
+	if item.ID == "" {
+		h := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", timeline.channel, time.Now().UnixNano())))
+		item.ID = hex.EncodeToString(h[:])
+	}
+
+	isNew, err := redis.Bool(conn.Do("HSETNX", timeline.idsKey(), item.ID, ""))
+	if err != nil {
+		return false, err
+	}
+	if !isNew {
+		return false, nil
+	}
+
 	data, err := json.Marshal(item)
 	if err != nil {
 		log.Printf("error while creating item for redis: %v\n", err)
@@ -104,26 +256,225 @@ func (timeline *redisStreamTimeline) AddItem(item microsub.Item) (bool, error) {
 
 	args := redis.Args{}.Add(timeline.channelKey).Add("*").Add("ID").Add(item.ID).Add("Published").Add(item.Published).Add("Read").Add(item.Read).Add("Data").Add(data)
 
-	_, err = redis.String(conn.Do("XADD", args...))
+	id, err := redis.String(conn.Do("XADD", args...))
+	if err != nil {
+		_, _ = conn.Do("HDEL", timeline.idsKey(), item.ID)
+		return false, err
+	}
+
+	if _, err = conn.Do("HSET", timeline.idsKey(), item.ID, id); err != nil {
+		return false, err
+	}
 
-	_, _ = conn.Do("XTRIM", timeline.channelKey, "MAXLEN", "~", "250")
+	if !item.Read {
+		if _, err = conn.Do("SADD", timeline.unreadKey(), item.ID); err != nil {
+			return false, err
+		}
+	}
 
-	return err == nil, err
+	return true, nil
 }
 
-func (timeline *redisStreamTimeline) Count() (int, error) {
-	conn := timeline.pool.Get()
+// Count returns the number of unread items
+func (timeline *redisStreamTimeline) Count(ctx context.Context) (int, error) {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return -1, err
+	}
 	defer conn.Close()
 
-	return redis.Int(conn.Do("XLEN", timeline.channelKey))
+	return redis.Int(conn.Do("SCARD", timeline.unreadKey()))
 }
 
-func (timeline *redisStreamTimeline) MarkRead(uids []string) error {
-	// panic("implement me")
+// MarkRead marks the items as read
+func (timeline *redisStreamTimeline) MarkRead(ctx context.Context, uids []string) error {
+	if len(uids) == 0 {
+		return nil
+	}
+
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("SREM", redis.Args{}.Add(timeline.unreadKey()).AddFlat(uids)...); err != nil {
+		return fmt.Errorf("marking read for channel %s has failed: %s", timeline.channel, err)
+	}
 	return nil
 }
 
-func (timeline *redisStreamTimeline) MarkUnread(uids []string) error {
-	// panic("implement me")
+// MarkReadUntil marks all items added at or before the item with uid as read
+func (timeline *redisStreamTimeline) MarkReadUntil(ctx context.Context, uid string) error {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	id, err := redis.String(conn.Do("HGET", timeline.idsKey(), uid))
+	if err == redis.ErrNil {
+		return ErrItemNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("marking read for channel %s has failed: %s", timeline.channel, err)
+	}
+
+	entries, err := parseStreamEntries(conn.Do("XRANGE", timeline.channelKey, "-", id))
+	if err != nil {
+		return fmt.Errorf("marking read for channel %s has failed: %s", timeline.channel, err)
+	}
+
+	var uids []string
+	for _, entry := range entries {
+		uids = append(uids, entry.Item.ID)
+	}
+
+	return timeline.MarkRead(ctx, uids)
+}
+
+// MarkUnread marks the items as unread
+func (timeline *redisStreamTimeline) MarkUnread(ctx context.Context, uids []string) error {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, uid := range uids {
+		known, err := redis.Bool(conn.Do("HEXISTS", timeline.idsKey(), uid))
+		if err != nil {
+			return fmt.Errorf("marking unread for channel %s has failed: %s", timeline.channel, err)
+		}
+		removed, err := redis.Bool(conn.Do("SISMEMBER", timeline.removedKey(), uid))
+		if err != nil {
+			return fmt.Errorf("marking unread for channel %s has failed: %s", timeline.channel, err)
+		}
+		if !known || removed {
+			continue
+		}
+		if _, err := conn.Do("SADD", timeline.unreadKey(), uid); err != nil {
+			return fmt.Errorf("marking unread for channel %s has failed: %s", timeline.channel, err)
+		}
+	}
+
 	return nil
 }
+
+// Remove hides the items from the channel. The uids are remembered, so the
+// items are not added again.
+func (timeline *redisStreamTimeline) Remove(ctx context.Context, uids []string) error {
+	if len(uids) == 0 {
+		return nil
+	}
+
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("SADD", redis.Args{}.Add(timeline.removedKey()).AddFlat(uids)...); err != nil {
+		return fmt.Errorf("removing items from channel %s has failed: %s", timeline.channel, err)
+	}
+	if _, err := conn.Do("SREM", redis.Args{}.Add(timeline.unreadKey()).AddFlat(uids)...); err != nil {
+		return fmt.Errorf("removing items from channel %s has failed: %s", timeline.channel, err)
+	}
+	return nil
+}
+
+// RemovedItems returns the removed items
+func (timeline *redisStreamTimeline) RemovedItems(ctx context.Context) ([]microsub.Item, error) {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	uids, err := redis.Strings(conn.Do("SMEMBERS", timeline.removedKey()))
+	if err != nil {
+		return nil, fmt.Errorf("while reading removed items of %s: %w", timeline.channel, err)
+	}
+
+	var items []microsub.Item
+	for _, uid := range uids {
+		id, err := redis.String(conn.Do("HGET", timeline.idsKey(), uid))
+		if err == redis.ErrNil || id == "" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseStreamEntries(conn.Do("XRANGE", timeline.channelKey, id, id))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			item := entry.Item.Item()
+			item.ID = uid
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// Clear deletes the stream and the sets and hash of the channel
+func (timeline *redisStreamTimeline) Clear(ctx context.Context) error {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", timeline.channelKey, timeline.idsKey(), timeline.unreadKey(), timeline.removedKey()); err != nil {
+		return fmt.Errorf("clearing channel %s has failed: %s", timeline.channel, err)
+	}
+	return nil
+}
+
+// ItemsByUID returns the items with the uids
+func (timeline *redisStreamTimeline) ItemsByUID(ctx context.Context, uids []string) ([]microsub.Item, error) {
+	conn, err := timeline.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var items []microsub.Item
+	for _, uid := range uids {
+		id, err := redis.String(conn.Do("HGET", timeline.idsKey(), uid))
+		if err == redis.ErrNil || id == "" {
+			return nil, ErrItemNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		removed, err := redis.Bool(conn.Do("SISMEMBER", timeline.removedKey(), uid))
+		if err != nil {
+			return nil, err
+		}
+		if removed {
+			return nil, ErrItemNotFound
+		}
+
+		entries, err := parseStreamEntries(conn.Do("XRANGE", timeline.channelKey, id, id))
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			return nil, ErrItemNotFound
+		}
+
+		unread, err := redis.Bool(conn.Do("SISMEMBER", timeline.unreadKey(), uid))
+		if err != nil {
+			return nil, err
+		}
+
+		item := entries[0].Item.Item()
+		item.ID = uid
+		item.Read = !unread
+		items = append(items, item)
+	}
+	return items, nil
+}
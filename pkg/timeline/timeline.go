@@ -21,12 +21,22 @@
 // "sorted-set" uses Redis sorted sets as a backend
 // "stream" uses Redis 5 streams as a backend
 // "null" doesn't remember any items added to it
+// "postgres-stream" uses Postgres as a backend
+// "memory" keeps the items in memory, for tests and embedded use
+//
+// Other types can be added with Register. The global and saved channels,
+// pruning and starring are only supported by "postgres-stream".
 package timeline
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/pstuifzand/ekster/pkg/microsub"
 
@@ -36,40 +46,183 @@ import (
 // Backend specifies the interface for Timeline. It supports everything that is needed
 // for Ekster to implement the channel protocol for Microsub
 type Backend interface {
-	Items(before, after string) (microsub.Timeline, error)
-	Count() (int, error)
+	Items(ctx context.Context, before, after string, filter microsub.TimelineFilter) (microsub.Timeline, error)
+	Count(ctx context.Context) (int, error)
+
+	AddItem(ctx context.Context, item microsub.Item) (bool, error)
+	MarkRead(ctx context.Context, uids []string) error
+	MarkReadUntil(ctx context.Context, uid string) error
+	MarkUnread(ctx context.Context, uids []string) error
+	Remove(ctx context.Context, uids []string) error
+	ItemsByUID(ctx context.Context, uid []string) ([]microsub.Item, error)
+}
+
+// PruneOptions selects the items that are deleted by Prune
+type PruneOptions struct {
+	// Before selects the items published before this time, when not zero
+	Before time.Time
+	// MaxItems selects all items except the newest MaxItems, when larger than zero
+	MaxItems int
+	// KeepUnread keeps the unread items, even when they are selected
+	KeepUnread bool
+	// KeepStarred keeps the starred items, even when they are selected
+	KeepStarred bool
+}
+
+// selects returns true when the item at position n (from new to old) with
+// the published date is selected by the options
+func (opts PruneOptions) selects(n int, published time.Time, read, starred bool) bool {
+	if opts.KeepUnread && !read {
+		return false
+	}
+	if opts.KeepStarred && starred {
+		return false
+	}
+	if !opts.Before.IsZero() && published.Before(opts.Before) {
+		return true
+	}
+	return opts.MaxItems > 0 && n >= opts.MaxItems
+}
+
+// Pruner is implemented by timelines that can delete old items. Items that are
+// pruned are not added again by AddItem.
+type Pruner interface {
+	// Prune deletes the items selected by opts and returns their uids
+	Prune(ctx context.Context, opts PruneOptions) ([]string, error)
+}
+
+// Deleter is implemented by timelines that can delete items. Deleted items
+// are not added again by AddItem.
+type Deleter interface {
+	// Delete deletes the items with the uids
+	Delete(ctx context.Context, uids []string) error
+}
 
-	AddItem(item microsub.Item) (bool, error)
-	MarkRead(uids []string) error
-	ItemsByUID(uid []string) ([]microsub.Item, error)
+// Clearer is implemented by timelines that can delete all their items. The
+// migration to another timeline type clears the old timeline after copying.
+type Clearer interface {
+	// Clear deletes all items of the timeline
+	Clear(ctx context.Context) error
+}
+
+// Starrer is implemented by timelines that can star items. Starred items are
+// not pruned when PruneOptions.KeepStarred is set.
+type Starrer interface {
+	Star(ctx context.Context, uids []string) error
+	Unstar(ctx context.Context, uids []string) error
+}
 
-	// Not used at the moment
-	// MarkUnread(uids []string) error
+// RemovedLister is implemented by timelines that remember removed items. Copy
+// uses it to keep the items removed in the copy.
+type RemovedLister interface {
+	// RemovedItems returns the items that were removed from the timeline
+	RemovedItems(ctx context.Context) ([]microsub.Item, error)
+}
+
+// ReadLister is implemented by timelines that don't return read items from
+// Items. Copy uses it to copy the read items too.
+type ReadLister interface {
+	// ReadItems returns the read items of the timeline, without the removed
+	// items
+	ReadItems(ctx context.Context) ([]microsub.Item, error)
+}
+
+// GlobalChannel is the uid of the timeline that contains the items of all
+// channels of a user
+const GlobalChannel = "global"
+
+// SavedChannel is the uid of the timeline that contains the starred items of
+// all channels of a user
+const SavedChannel = "saved"
+
+// Factory creates a timeline of a registered type for channel
+type Factory func(ctx context.Context, channel string, pool *redis.Pool, db *sql.DB) (Backend, error)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = make(map[string]Factory)
+)
+
+// Register makes a timeline type available to Create. Register panics when
+// the type is registered twice or when factory is nil.
+func Register(timelineType string, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	if factory == nil {
+		panic("timeline: Register factory is nil")
+	}
+	if _, dup := factories[timelineType]; dup {
+		panic("timeline: Register called twice for type " + timelineType)
+	}
+	factories[timelineType] = factory
+}
+
+// Types returns the sorted list of registered timeline types
+func Types() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	var types []string
+	for timelineType := range factories {
+		types = append(types, timelineType)
+	}
+	sort.Strings(types)
+	return types
 }
 
 // Create creates a channel of the specified type. Return nil when the type
-// is not known.
-func Create(channel, timelineType string, pool *redis.Pool, db *sql.DB) Backend {
-	if timelineType == "null" {
-		timeline := &nullTimeline{channel: channel}
-		err := timeline.Init()
-		if err != nil {
-			return nil
-		}
-		return timeline
+// is not known or the timeline can't be created.
+func Create(ctx context.Context, channel, timelineType string, pool *redis.Pool, db *sql.DB) Backend {
+	factoriesLock.RLock()
+	factory, ok := factories[timelineType]
+	factoriesLock.RUnlock()
+	if !ok {
+		log.Printf("Error while creating %s: unknown timeline type %q", channel, timelineType)
+		return nil
 	}
 
-	if timelineType == "postgres-stream" {
-		timeline := &postgresStream{database: db, channel: channel}
-		err := timeline.Init()
+	timeline, err := factory(ctx, channel, pool, db)
+	if err != nil {
+		log.Printf("Error while creating %s: %v", channel, err)
+		return nil
+	}
+	return timeline
+}
+
+// CreateGlobal creates the timeline that contains the items of all channels of
+// the user. Return nil when the timeline can't be created.
+func CreateGlobal(ctx context.Context, userID int, db *sql.DB) Backend {
+	timeline := &postgresStream{database: db, channel: GlobalChannel, userID: userID}
+	err := timeline.Init(ctx)
+	if err != nil {
+		log.Printf("Error while creating %s: %v", GlobalChannel, err)
+		return nil
+	}
+	return timeline
+}
+
+// CreateSaved creates the timeline that contains the starred items of all
+// channels of the user. Return nil when the timeline can't be created.
+func CreateSaved(ctx context.Context, userID int, db *sql.DB) Backend {
+	timeline := &postgresStream{database: db, channel: SavedChannel, userID: userID}
+	err := timeline.Init(ctx)
+	if err != nil {
+		log.Printf("Error while creating %s: %v", SavedChannel, err)
+		return nil
+	}
+	return timeline
+}
+
+// parsePublished parses the published date of an item
+func parsePublished(published string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02T15:04:05Z0700", published)
+	if err != nil {
+		t2, err := time.Parse("2006-01-02T15:04:05Z07:00", published)
 		if err != nil {
-			log.Printf("Error while creating %s: %v", channel, err)
-			return nil
+			return time.Time{}, fmt.Errorf("time %q could not be parsed: %w", published, err)
 		}
-		return timeline
+		t = t2
 	}
-
-	return nil
+	return t, nil
 }
 
 type redisItem struct {
@@ -19,16 +19,34 @@
 package util
 
 import (
-	"math/rand"
+	"crypto/rand"
 )
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
-// RandStringBytes generates a random string of n characters
+// maxLetterByte is the largest multiple of len(letterBytes) that fits in a
+// byte. Random bytes from maxLetterByte up are skipped, so every letter has
+// the same chance.
+const maxLetterByte = 256 / len(letterBytes) * len(letterBytes)
+
+// RandStringBytes generates a random string of n characters. The characters
+// come from crypto/rand, so the string can be used for secrets and tokens.
 func RandStringBytes(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+	b := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(b) < n {
+		if _, err := rand.Read(buf); err != nil {
+			panic("util: reading random bytes failed: " + err.Error())
+		}
+		for _, r := range buf {
+			if int(r) >= maxLetterByte {
+				continue
+			}
+			b = append(b, letterBytes[int(r)%len(letterBytes)])
+			if len(b) == n {
+				break
+			}
+		}
 	}
 	return string(b)
 }
@@ -21,28 +21,42 @@ package websub
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"strings"
 
 	"github.com/pkg/errors"
 )
 
-// ValidateHubSignature validate a sha1 signature that could be send with the
-// hub as an extra header
+// signatureAlgorithms contains the hash functions of the signature methods
+// that hubs can use in the X-Hub-Signature header
+var signatureAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// ValidateHubSignature validates the X-Hub-Signature header that the hub
+// sends with the content. The signature looks like method=signature, where
+// method is sha1, sha256, sha384 or sha512.
 func ValidateHubSignature(sig string, feedContent, secret []byte) error {
-	parts := strings.Split(sig, "=")
+	parts := strings.SplitN(sig, "=", 2)
 
 	if len(parts) != 2 {
-		return errors.New("signature format is not like sha1=signature")
+		return errors.New("signature format is not like method=signature")
 	}
 
-	if parts[0] != "sha1" {
-		return errors.New("signature format is not like sha1=signature")
+	newHash, ok := signatureAlgorithms[parts[0]]
+	if !ok {
+		return fmt.Errorf("unsupported signature method %q", parts[0])
 	}
 
 	// verification
-	mac := hmac.New(sha1.New, secret)
+	mac := hmac.New(newHash, secret)
 	mac.Write(feedContent)
 	signature := mac.Sum(nil)
 
@@ -52,7 +66,7 @@ func ValidateHubSignature(sig string, feedContent, secret []byte) error {
 	}
 
 	if !hmac.Equal(signature, signature2) {
-		return fmt.Errorf("signature does not match feed %s %s", signature, parts[1])
+		return errors.New("signature does not match content")
 	}
 
 	return nil
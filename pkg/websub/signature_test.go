@@ -38,3 +38,29 @@ func TestValidateHubSignature(t *testing.T) {
 	err := ValidateHubSignature(fmt.Sprintf("sha1=%x", signature), feedContent, secret)
 	assert.NoError(t, err, "error should be nil")
 }
+
+func TestValidateHubSignature_Methods(t *testing.T) {
+	secret := []byte("this is a test secret")
+	feedContent := []byte("hello world")
+
+	for method, newHash := range signatureAlgorithms {
+		mac := hmac.New(newHash, secret)
+		mac.Write(feedContent)
+		signature := mac.Sum(nil)
+
+		err := ValidateHubSignature(fmt.Sprintf("%s=%x", method, signature), feedContent, secret)
+		assert.NoError(t, err, method)
+
+		err = ValidateHubSignature(fmt.Sprintf("%s=%x", method, signature), []byte("changed"), secret)
+		assert.Error(t, err, method)
+	}
+}
+
+func TestValidateHubSignature_Invalid(t *testing.T) {
+	secret := []byte("this is a test secret")
+	feedContent := []byte("hello world")
+
+	for _, sig := range []string{"", "sha1", "md5=5eb63bbbe01eeed093cb22bb8f5acdc3", "sha256=zz"} {
+		assert.Error(t, ValidateHubSignature(sig, feedContent, secret), sig)
+	}
+}
@@ -165,3 +165,32 @@ func Subscribe(client *http.Client, hubURL, topicURL, callbackURL, secret string
 
 	return nil
 }
+
+// Unsubscribe asks hubURL to stop sending updates of topicURL to callbackURL.
+// The hub verifies the request with a GET request on the callback url, with
+// hub.mode set to "unsubscribe".
+func Unsubscribe(client *http.Client, hubURL, topicURL, callbackURL string) error {
+	hub, err := url.Parse(hubURL)
+	if err != nil {
+		return err
+	}
+
+	q := hub.Query()
+	q.Add("hub.mode", "unsubscribe")
+	q.Add("hub.callback", callbackURL)
+	q.Add("hub.topic", topicURL)
+	hub.RawQuery = ""
+
+	res, err := client.PostForm(hub.String(), q)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("hub %s did not accept unsubscribe of %s: %s", hubURL, topicURL, res.Status)
+	}
+
+	return nil
+}
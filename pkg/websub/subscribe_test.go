@@ -0,0 +1,51 @@
+/*
+ *  Ekster is a microsub server
+ *  Copyright (c) 2022 The Ekster authors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package websub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsubscribe(t *testing.T) {
+	var form url.Values
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		form = r.PostForm
+		if form.Get("hub.topic") == "https://example.com/unknown" {
+			http.Error(w, "unknown topic", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+
+	err := Unsubscribe(http.DefaultClient, hub.URL, "https://example.com/feed", "https://ekster.example.com/incoming/1")
+	assert.NoError(t, err)
+	assert.Equal(t, "unsubscribe", form.Get("hub.mode"))
+	assert.Equal(t, "https://example.com/feed", form.Get("hub.topic"))
+	assert.Equal(t, "https://ekster.example.com/incoming/1", form.Get("hub.callback"))
+
+	err = Unsubscribe(http.DefaultClient, hub.URL, "https://example.com/unknown", "https://ekster.example.com/incoming/1")
+	assert.Error(t, err)
+}